@@ -1,12 +1,14 @@
 package quark
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"reflect"
 	"runtime/debug"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -33,13 +35,19 @@ var (
 )
 
 type Quark struct {
-	lock      sync.Mutex
-	Marshal   JsonMarshalFunc
-	Unmarshal JsonUnmarshalFunc
-	Services  []Service
-	smap      map[string]int
-	swagger   *spec.Swagger
-	option    *Option
+	lock            sync.Mutex
+	Marshal         JsonMarshalFunc
+	Unmarshal       JsonUnmarshalFunc
+	Services        []Service
+	smap            map[string]int // by mount path of services
+	router          *router
+	swagger         *spec.Swagger
+	versionSwaggers map[string]*spec.Swagger
+	docsCache       *docsCache
+	option          *Option
+	middlewares     []Middleware
+	codecs          codecs
+	providers       map[reflect.Type]reflect.Value
 }
 
 func (q *Quark) SwaggerSpec() *spec.Swagger {
@@ -51,6 +59,9 @@ func (q *Quark) SwaggerSpec() *spec.Swagger {
 	q.swagger = new(spec.Swagger)
 	q.swagger.Paths = new(spec.Paths)
 	q.swagger.Paths.Paths = make(map[string]spec.PathItem)
+	if len(q.option.PathPrefix) > 0 {
+		q.swagger.BasePath = "/" + strings.Join(q.option.PathPrefix, "/")
+	}
 	for _, service := range q.Services {
 		q.swagger.SwaggerProps.Tags = append(q.swagger.SwaggerProps.Tags, spec.NewTag(service.Name, service.ServiceType.Name(), nil))
 		for _, api := range service.Apis {
@@ -58,7 +69,10 @@ func (q *Quark) SwaggerSpec() *spec.Swagger {
 			if item == nil {
 				continue
 			}
-			q.swagger.Paths.Paths[api.docPath] = *item
+			path := service.mountPath() + api.docPath
+			merged := q.swagger.Paths.Paths[path]
+			mergePathItem(&merged, item)
+			q.swagger.Paths.Paths[path] = merged
 		}
 	}
 	q.swagger.Swagger = "2.0"
@@ -72,76 +86,133 @@ func (q *Quark) SwaggerSpec() *spec.Swagger {
 	return q.swagger
 }
 
+// Register adds services, each one is a struct instance, or a func() S making the instance of every request.
+// A service is mounted at its type name, or the path returned by its ServiceName method.
+// Nothing is registered if any api is invalid, the returned RegisterError lists the problems of all of them
+func (q *Quark) Register(instances ...interface{}) error {
+	return q.register(nil, nil, instances)
+}
+
+// RegisterService is Register which panics on the error
 func (q *Quark) RegisterService(instances ...interface{}) {
+	if e := q.Register(instances...); e != nil {
+		panic(e)
+	}
+}
+
+// register mounts instances under group g, by mount instead of their own names when it's not nil
+func (q *Quark) register(g *Group, mount *string, instances []interface{}) error {
 	q.lock.Lock()
 	defer q.lock.Unlock()
+	var (
+		errs     RegisterError
+		services []*Service
+		names    = make(map[string]bool)
+	)
+	for _, inst := range instances {
+		s, e := q.newService(inst, g, mount)
+		errs = append(errs, e...)
+		if s == nil {
+			continue
+		}
+		if _, ok := q.smap[s.Name]; ok || names[s.Name] {
+			errs = append(errs, fmt.Errorf("service %s: registered twice", s.Name))
+		}
+		names[s.Name] = true
+		services = append(services, s)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	shadowedBy, errs := q.checkRoutes(services)
+	if len(errs) > 0 {
+		return errs
+	}
 	if q.smap == nil {
 		q.smap = make(map[string]int)
 	}
-	for _, inst := range instances {
-		t := reflect.TypeOf(inst)
-		s := q.newService(t)
+	for _, s := range services {
 		q.Services = append(q.Services, *s)
 		q.smap[s.Name] = len(q.Services) - 1
 	}
-	q.swagger = nil
+	for i := range q.Services {
+		for j := range q.Services[i].Apis {
+			a := &q.Services[i].Apis[j]
+			a.shadowedBy = shadowedBy[a]
+			sort.Strings(a.shadowedBy)
+		}
+	}
+	q.router = newRouter(q.Services)
+	q.swagger, q.versionSwaggers, q.docsCache = nil, nil, nil
+	return nil
 }
 
 func (q *Quark) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if exception := recover(); exception != nil {
-			t := reflect.TypeOf(exception)
-			if t == haltPanicType {
-				hp := exception.(haltPanic)
-				w.WriteHeader(hp.Status)
-				w.Write(hp.Body)
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(fmt.Sprintf("%v\n\n", exception)))
-				w.Write([]byte(debug.Stack()))
+			switch x := exception.(type) {
+			case haltPanic:
+				if x.ContentType != "" {
+					w.Header().Set("Content-Type", x.ContentType)
+				}
+				w.WriteHeader(x.Status)
+				w.Write(x.Body)
+			case apiPanic:
+				writeProblem(w, r, q.panicProblem(x.Value, x.Stack))
+			default:
+				writeProblem(w, r, q.panicProblem(exception, debug.Stack()))
 			}
 		}
 	}()
-	path := r.URL.Path
-	if path != "" && path[0] == '/' {
-		path = path[1:]
-	}
-	pathElems := strings.Split(path, "/")
-	//firstly, find handler by leading service name
-	for len(pathElems) > 0 {
-		pe := pathElems
-		if k := len(q.option.PathPrefix); k > 0 {
-			if len(pe) < k {
-				w.WriteHeader(http.StatusNotFound)
+	var vars *[]string
+	if q.router != nil {
+		vars = q.router.vars.Get().(*[]string)
+		defer q.router.vars.Put(vars)
+		*vars = (*vars)[:0]
+	}
+	if q.option.Docs != nil {
+		fold := q.option.CaseInsensitive
+		if rest, ok := q.stripPrefix(r.URL.Path, fold); ok && q.serveDocs(w, r, rest, fold) {
+			return
+		}
+	}
+	if isPreflight(r) {
+		method := r.Header.Get("Access-Control-Request-Method")
+		if api, _, _ := q.route(method, r.URL.Path, vars); api != nil {
+			if cors := q.cors(api); cors != nil {
+				cors.preflight(w, r, method)
 				return
 			}
-			for i := 0; i < k; i++ {
-				if q.option.PathPrefix[i] != pe[i] {
-					w.WriteHeader(http.StatusNotFound)
-					return
-				}
-			}
-			pe = pe[k:]
 		}
-		serviceIndex, ok := q.smap[pe[0]]
-		if !ok {
-			break
+		if vars != nil {
+			*vars = (*vars)[:0]
 		}
-		if q.Services[serviceIndex].Route(w, r, pe[1:]) {
-			return
-		}
-		break
 	}
-	// if not hit, try root handler
-	rootServiceIndex, ok := q.smap[ROOT_SERVICE_NAME]
-	if !ok {
-		w.WriteHeader(http.StatusGone)
-		return
+	api, allowed, redirect := q.route(r.Method, r.URL.Path, vars)
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if cors := q.cors(api); cors != nil {
+			cors.actual(w.Header(), origin)
+		}
 	}
-	if q.Services[rootServiceIndex].Route(w, r, pathElems) {
-		return
+	switch {
+	case api != nil && r.Method == http.MethodHead && api.Method == http.MethodGet:
+		api.Run(headWriter{w}, r, *vars)
+	case api != nil:
+		api.Run(w, r, *vars)
+	case redirect != "":
+		redirectTrailingSlash(w, r, redirect)
+	case allowed != nil && r.Method == http.MethodOptions:
+		w.Header().Set("Allow", allowed.allow)
+		w.WriteHeader(http.StatusNoContent)
+	case allowed != nil:
+		w.Header().Set("Allow", allowed.allow)
+		writeProblem(w, r, NewProblem(http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed, allowed are "+allowed.allow))
+	case r.Method == http.MethodOptions && r.URL.Path == "*":
+		w.Header().Set("Allow", strings.Join(allowOrder, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeProblem(w, r, NewProblem(http.StatusNotFound, "no api of "+r.URL.Path))
 	}
-	w.WriteHeader(http.StatusGone)
 }
 
 func (q *Quark) addModel(t reflect.Type) {
@@ -155,52 +226,24 @@ func (q *Quark) addModel(t reflect.Type) {
 }
 
 type Service struct {
-	Name          string
-	ServiceType   reflect.Type
-	Apis          []Api
-	atrie         util.Trie // path format with {%} mark; there's a final tire indicating method, by :GET, :POST or : for ANY
-	quarkInstance *Quark
+	Name           string // mount path
+	ServiceType    reflect.Type
+	Version        string // by Quark.Version
+	Apis           []Api
+	quarkInstance  *Quark
+	middlewares    []Middleware
+	instance       reflect.Value // registered instance copied into every receiver
+	factory        reflect.Value // or func() S making every receiver
+	injectFields   []injectField
+	routeOverrides map[string]string // by RouteDefiner, used at registration
+	cors           *CORS             // by CORSDefiner, overrides Option.CORS
 }
 
+// DumpPaths prints the routes of the service, Quark.Routes is preferred for a readable table
 func (s Service) DumpPaths() {
-	s.atrie.Dump()
-}
-
-func (s *Service) route(pathElems []string, trie util.Trie) (methodTrie util.Trie) {
-	if len(pathElems) == 0 {
-		return trie
-	}
-	choices := PathElementChoices(pathElems[0])
-	for i := range choices {
-		sub := trie.Sub(choices[i])
-		if sub.Valid() {
-			candidate := s.route(pathElems[1:], sub)
-			if candidate.Valid() {
-				return candidate
-			}
-		}
+	for i := range s.Apis {
+		fmt.Println(s.Apis[i].methodName(), s.quarkInstance.servicePath(&s)+s.Apis[i].docPath)
 	}
-	return util.NilTrie
-}
-
-func (s *Service) Route(w http.ResponseWriter, r *http.Request, pathElems []string) (accepted bool) {
-	methodTrie := s.route(pathElems, s.atrie)
-	if !methodTrie.Valid() {
-		return false
-	}
-	apiIndexTrie, ok := methodTrie.Find([]string{":" + r.Method})
-	if !ok {
-		apiIndexTrie, ok = methodTrie.Find([]string{":"})
-	}
-	if !ok {
-		return false
-	}
-	if index := apiIndexTrie.Value(); index == nil {
-		return false
-	} else {
-		s.Apis[*index].Run(w, r, pathElems)
-	}
-	return true
 }
 
 func (s *Service) Quark() *Quark {
@@ -215,10 +258,26 @@ type Api struct {
 	noBody          bool
 	Request         reflect.Type
 	Response        reflect.Type
+	errorOut        int // index of the trailing error return value, -1 if none
+	stream          streamKind
+	websocket       bool
+	args            []argKind             // kinds of method parameters after the receiver
+	provided        map[int]reflect.Value // providers of argProvided parameters by position in args
+	MaxBodySize     int64                 // overrides Option.MaxBodySize when > 0
+	Timeout         time.Duration         // overrides Option.Timeout when > 0, disables it when < 0
+	Deprecation     *Deprecation          // by Group.Deprecate
 	ReflectMethod   reflect.Method
 	PathVars        []util.PathVar // key: path element pos, value: i/s/f.{varname}
 	QueryVars       map[string]int // key: var name, value: pos in req struct
+	HeaderVars      map[string]int // key: header name, value: pos in req struct
+	CookieVars      map[string]int // key: cookie name, value: pos in req struct
+	FormVars        map[string]int // key: form field name, value: pos in req struct
+	FileVars        map[string]int // key: multipart file name, value: pos in req struct
+	validators      []fieldValidator
+	shadowedBy      []string // handlers of apis matched before this one on the paths both match
+	plan            *bindPlan
 	serviceInstance *Service
+	middlewares     []Middleware
 }
 
 func (a *Api) SwaggerPathItem() *spec.PathItem {
@@ -243,9 +302,22 @@ func (a *Api) SwaggerPathItem() *spec.PathItem {
 	return pi
 }
 
+// mergePathItem copies the operations of src into dst, so apis of the same path and different methods are all kept
+func mergePathItem(dst, src *spec.PathItem) {
+	for _, op := range []struct{ dst, src **spec.Operation }{
+		{&dst.Get, &src.Get}, {&dst.Post, &src.Post}, {&dst.Delete, &src.Delete}, {&dst.Patch, &src.Patch},
+		{&dst.Put, &src.Put}, {&dst.Head, &src.Head}, {&dst.Options, &src.Options},
+	} {
+		if *op.src != nil {
+			*op.dst = *op.src
+		}
+	}
+}
+
 func (a *Api) SwaggerOperations() *spec.Operation {
 	op := new(spec.Operation)
 	op.Tags = []string{a.Service().Name}
+	op.Deprecated = a.Deprecation != nil
 	for _, pv := range a.PathVars {
 		ss := strings.SplitN(pv.Var, ".", 2)
 		var typ string
@@ -268,43 +340,76 @@ func (a *Api) SwaggerOperations() *spec.Operation {
 			},
 		})
 	}
+	if a.hasArg(argBody) {
+		op.Consumes = []string{MediaTypeOctetStream}
+		op.Parameters = append(op.Parameters, spec.Parameter{
+			ParamProps: spec.ParamProps{
+				Name:     "request-body",
+				In:       "body",
+				Required: true,
+				Schema:   &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"string"}, Format: "binary"}},
+			},
+		})
+	}
 	if a.Request != nil {
 		hasBody := false
+		hasForm := false
+		hasFile := false
 		for i := 0; i < a.Request.NumField(); i++ {
 			f := a.Request.Field(i)
-			var dataType reflect.Type = f.Type
-			var nullable bool
-			for dataType.Kind() == reflect.Ptr {
-				dataType = dataType.Elem()
-				nullable = true
+			if IsFileType(f.Type) {
+				hasFile = true
+				param := spec.Parameter{
+					ParamProps: spec.ParamProps{
+						Name:     a.Service().Quark().option.PathNaming.field(f),
+						In:       "formData",
+						Required: f.Type == FileType,
+					},
+					SimpleSchema: spec.SimpleSchema{
+						Type: "file",
+					},
+				}
+				if fr, e := ParseFieldRules(f); e == nil && fr.Required {
+					param.Required = true
+				}
+				op.Parameters = append(op.Parameters, param)
+				continue
 			}
-			var typ string
-			var in string
-			switch dataType {
-			case IntType:
-				typ = "integer"
-				in = "query"
-			case NumberType:
-				typ = "number"
-				in = "query"
-			case StringType:
-				typ = "string"
-				in = "query"
-			default: //body field
+			if !IsUrlType(f.Type) { //body field
 				hasBody = true
 				continue
 			}
-			op.Parameters = append(op.Parameters, spec.Parameter{
+			elem, repeated := urlElemType(f.Type)
+			nullable := f.Type.Kind() == reflect.Ptr
+			taf := urlTypeFormats[elem]
+			in := QuarkTagIn(f)
+			if in == InForm {
+				in = "formData"
+				hasForm = true
+			}
+			param := spec.Parameter{
 				ParamProps: spec.ParamProps{
-					Name:     f.Name,
+					Name:     a.Service().Quark().option.PathNaming.field(f),
 					In:       in,
-					Required: !nullable,
+					Required: !nullable && !repeated,
 				},
 				SimpleSchema: spec.SimpleSchema{
-					Type:     typ,
+					Type:     taf.T,
+					Format:   taf.F,
 					Nullable: nullable,
 				},
-			})
+			}
+			if repeated {
+				param.SimpleSchema = spec.SimpleSchema{
+					Type:             "array",
+					Items:            &spec.Items{SimpleSchema: spec.SimpleSchema{Type: taf.T, Format: taf.F}},
+					CollectionFormat: "multi",
+				}
+			}
+			if fr, e := ParseFieldRules(f); e == nil {
+				fr.applyParameter(&param, f.Type)
+			}
+			op.Parameters = append(op.Parameters, param)
 		}
 		if hasBody {
 			var schema spec.Schema
@@ -314,6 +419,7 @@ func (a *Api) SwaggerOperations() *spec.Operation {
 			} else { //Anonymous local schema
 				schema = a.Service().Quark().SwaggerSchemaFromType(a.Request, true)
 			}
+			op.Consumes = a.Service().Quark().mediaTypesFor(a.Request)
 			op.Parameters = append(op.Parameters, spec.Parameter{
 				ParamProps: spec.ParamProps{
 					Name:     "request-body",
@@ -322,12 +428,26 @@ func (a *Api) SwaggerOperations() *spec.Operation {
 					Schema:   &schema,
 				},
 			})
+		} else if hasFile {
+			op.Consumes = []string{MediaTypeMultipart}
+		} else if hasForm {
+			op.Consumes = []string{MediaTypeForm, MediaTypeMultipart}
 		}
 	}
 	var rsp200 *spec.Schema
-	if a.Response != nil {
+	switch {
+	case a.Response == nil:
+	case a.stream == streamChan:
+		rsp200 = new(spec.Schema)
+		*rsp200 = arraySchemaWrap(a.Service().Quark().SwaggerSchemaFromType(a.Response.Elem(), false))
+		op.Produces = []string{MediaTypeNdjson, MediaTypeEventStream}
+	case a.stream != streamNone:
+		rsp200 = &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"file"}}}
+		op.Produces = []string{MediaTypeOctetStream}
+	default:
 		rsp200 = new(spec.Schema)
 		*rsp200 = a.Service().Quark().SwaggerSchemaFromType(a.Response, false)
+		op.Produces = a.Service().Quark().mediaTypesFor(a.Response)
 	}
 	op.Responses = &spec.Responses{
 		ResponsesProps: spec.ResponsesProps{
@@ -337,166 +457,266 @@ func (a *Api) SwaggerOperations() *spec.Operation {
 						Schema: rsp200,
 					},
 				},
-				/*
-					400: {},
-					401: {},
-					404: {},
-					500: {},
-				*/
 			},
 		},
 	}
+	if len(a.PathVars) > 0 || a.Request != nil {
+		op.Responses.StatusCodeResponses[400] = *spec.NewResponse().WithDescription("Invalid request parameters").WithSchema(problemSchema())
+	}
+	if a.Service().Quark().option.Authenticate != nil {
+		op.Responses.StatusCodeResponses[401] = *spec.NewResponse().WithDescription("Authentication failed").WithSchema(problemSchema())
+	}
+	if a.hasArg(argEventStream) {
+		op.Produces = []string{MediaTypeEventStream}
+	}
+	if a.websocket {
+		op.Description = "WebSocket api, the connection is upgraded by GET"
+		delete(op.Responses.StatusCodeResponses, http.StatusOK)
+		op.Responses.StatusCodeResponses[http.StatusSwitchingProtocols] = *spec.NewResponse().WithDescription("Switching Protocols")
+	}
+	if a.errorOut >= 0 {
+		op.Responses.Default = spec.NewResponse().WithDescription("Error returned by the api, status decided by HTTPError").WithSchema(problemSchema())
+	}
 	return op
 }
 
-func (a *Api) Run(w http.ResponseWriter, r *http.Request, pathElems []string) {
-	body, e := ioutil.ReadAll(r.Body)
-	if e != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("read request body fail, %v", e)))
-		return
-	}
-	r.Body.Close()
-	r.ParseForm()
-	var console = Console{
-		w:     w,
-		r:     r,
-		body:  body,
-		quark: a.Service().Quark(),
+// Run serves r by the api, pathVars are the values of the path vars in order
+func (a *Api) Run(w http.ResponseWriter, r *http.Request, pathVars []string) {
+	if a.Deprecation != nil {
+		a.Deprecation.writeHeaders(w.Header())
 	}
-	if authFunc := a.Service().Quark().option.Authenticate; authFunc != nil {
-		if !authFunc(&console) {
-			w.WriteHeader(http.StatusUnauthorized)
+	var body []byte
+	limit := a.maxBodySize()
+	switch {
+	case a.plan.readsBody: // the handler reads the body itself
+		if limit > 0 {
+			r.Body = limitBody(r.Body, limit)
+		}
+	case isMultipart(r):
+		if status, e := a.Service().Quark().parseMultipart(r, limit); e != nil {
+			writeProblem(w, r, NewProblem(status, fmt.Sprintf("parse multipart form fail, %v", e)))
 			return
 		}
-	}
-	objV := reflect.New(a.ReflectMethod.Type.In(0)).Elem()
-	if objV.Kind() == reflect.Struct && objV.NumField() > 0 {
-		if consoleValue := objV.Field(0); consoleValue.Type() == consoleType {
-			consoleValue.Set(reflect.ValueOf(console))
+	case r.Body == nil || r.Body == http.NoBody:
+	default:
+		var e error
+		if limit > 0 {
+			r.Body = limitBody(r.Body, limit)
 		}
-	}
-	in := []reflect.Value{objV}
-	for _, pv := range a.PathVars {
-		argV := reflect.New(a.ReflectMethod.Type.In(len(in))).Elem()
-		arg := pathElems[pv.Pos]
-		switch pv.Var[0] {
-		case 'i':
-			i, e := strconv.ParseInt(arg, 10, 64)
-			if e != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(e.Error()))
-				return
-			}
-			argV.SetInt(i)
-		case 'f':
-			f, e := strconv.ParseFloat(arg, 64)
-			if e != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(e.Error()))
-				return
+		body, e = readBody(r.Body)
+		if e != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(e, ErrBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
 			}
-			argV.SetFloat(f)
-		case 's':
-			argV.SetString(arg)
+			writeProblem(w, r, NewProblem(status, fmt.Sprintf("read request body fail, %v", e)))
+			return
+		}
+		r.Body.Close()
+		if body == nil {
+			r.Body = http.NoBody
+		} else {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if a.plan.parseForm || len(a.plan.query) > 0 && hasMediaType(r, MediaTypeForm) {
+			r.ParseForm()
 		}
-		in = append(in, argV)
 	}
-	if a.Request != nil {
-		reqV := reflect.New(a.Request)
-		if !a.noBody && len(body) > 0 {
-			e := a.Service().Quark().Unmarshal(body, reqV.Interface())
-			if e != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(e.Error()))
-				return
+	r, cancel := a.withTimeout(r)
+	defer cancel()
+	state := &runState{w: responseWriter{ResponseWriter: w}}
+	state.console = Console{
+		w:        &state.w,
+		r:        r,
+		body:     body,
+		quark:    a.Service().Quark(),
+		api:      a,
+		pathVars: pathVars,
+		store:    &state.store,
+	}
+	if form := r.MultipartForm; form != nil {
+		defer func() {
+			if !state.w.timedOut { // finishLate removes it once the method returns
+				form.RemoveAll()
 			}
+		}()
+	}
+	defer state.console.recoverHalt()
+	a.handler()(&state.console)
+}
+
+// runState holds the per-request values of Run in a single allocation
+type runState struct {
+	console Console
+	w       responseWriter
+	store   valueStore
+}
+
+// serve is the innermost Handler, it authenticates, binds arguments, calls the method and writes the response
+func (a *Api) serve(c *Console) {
+	defer c.recoverHalt()
+	w := c.w
+	if authFunc := a.Service().Quark().option.Authenticate; authFunc != nil {
+		if !authFunc(c) {
+			writeProblem(w, c.r, NewProblem(http.StatusUnauthorized, ""))
+			return
 		}
-		for k, pos := range a.QueryVars {
-			f := reqV.Elem().Field(pos)
-			vs := r.Form[k]
-			if len(vs) == 0 || (len(vs) == 1 && vs[0] == "") {
-				f.Set(reflect.Zero(f.Type()))
-			} else {
-				switch f.Type() {
-				case StringType:
-					f.SetString(vs[0])
-				case StringPointerType:
-					f.Set(reflect.New(StringType))
-					f.Elem().SetString(vs[0])
-				case IntType, IntPointerType:
-					i, e := strconv.ParseInt(vs[0], 10, 64)
-					if e != nil {
-						w.WriteHeader(http.StatusBadRequest)
-						w.Write([]byte(fmt.Sprintf("Failed to parse query parameter %s as int", k)))
-						return
-					}
-					if f.Type() == IntType {
-						f.SetInt(i)
-					} else {
-						f.Set(reflect.New(IntType))
-						f.Elem().SetInt(i)
-					}
-				case NumberType, NumberPointerType:
-					n, e := strconv.ParseFloat(vs[0], 64)
-					if e != nil {
-						w.WriteHeader(http.StatusBadRequest)
-						w.Write([]byte(fmt.Sprintf("Failed to parse query parameter %s as number", k)))
-						return
-					}
-					if f.Type() == NumberType {
-						f.SetFloat(n)
-					} else {
-						f.Set(reflect.New(NumberType))
-						f.Elem().SetFloat(n)
-					}
-				default:
-					// impossible
-					// other types or slices are not supported yet, see newApi
-				}
+	}
+	if !c.negotiate() && a.Response != nil && a.stream == streamNone {
+		writeProblem(w, c.r, NewProblem(http.StatusNotAcceptable, "no acceptable media type of "+c.r.Header.Get("Accept")))
+		return
+	}
+	args := a.plan.arguments()
+	if a.timeout() <= 0 { // with a deadline the method may still run after serve returns
+		defer a.plan.release(args)
+	}
+	in := *args
+	in[0] = a.Service().receiver(c)
+	pathVarIndex := 0
+	var socket Socket
+	for i, kind := range a.args {
+		switch kind {
+		case argPathVar:
+			in[i+1] = a.plan.pathVars[pathVarIndex].bind(c)
+			pathVarIndex++
+		case argRequest:
+			in[i+1] = a.bindRequest(c)
+		case argBody:
+			in[i+1] = reflect.ValueOf(c.r.Body)
+		case argEventStream:
+			es, stop := a.bindEventStream(c)
+			defer stop()
+			in[i+1] = es
+		case argSocket:
+			socket = a.bindSocket(c)
+			defer socket.Close()
+			in[i+1] = reflect.ValueOf(socket)
+		case argContext:
+			in[i+1] = reflect.ValueOf(c.r.Context())
+		case argProvided:
+			in[i+1] = a.provided[i].Call(nil)[0]
+		}
+	}
+	out := a.call(c, in)
+	if out == nil {
+		return
+	}
+	if a.websocket {
+		if a.errorOut >= 0 {
+			if ev := out[a.errorOut]; !ev.IsNil() {
+				socket.CloseWith(CloseInternalError, ev.Interface().(error).Error())
 			}
 		}
-		in = append(in, reqV.Elem())
+		return
 	}
-	out := a.ReflectMethod.Func.Call(in)
-	if len(out) > 0 {
-		b, e := a.Service().Quark().Marshal(out[0].Interface())
-		if e != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(e.Error()))
+	if a.errorOut >= 0 {
+		if ev := out[a.errorOut]; !ev.IsNil() {
+			a.Service().Quark().encodeError(c, ev.Interface().(error))
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(b)
 	}
-	return
+	if a.Response == nil {
+		return
+	}
+	if a.stream != streamNone {
+		a.writeStream(c, out[0])
+		return
+	}
+	b, e := c.codec.Marshal(out[0].Interface())
+	if e != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(e, ErrUnsupportedType) {
+			status = http.StatusNotAcceptable
+		}
+		writeProblem(w, c.r, NewProblem(status, e.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", c.mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
 }
 
 func (api *Api) Service() *Service {
 	return api.serviceInstance
 }
 
-func (q *Quark) newService(t reflect.Type) (s *Service) {
+// Name returns the name of the service method
+func (api *Api) Name() string {
+	return api.ReflectMethod.Name
+}
+
+func (q *Quark) newService(inst interface{}, g *Group, mount *string) (s *Service, errs RegisterError) {
+	t := reflect.TypeOf(inst)
+	hooks := inst
+	if t == nil {
+		return nil, RegisterError{fmt.Errorf("service <nil>: only allow struct type")}
+	}
+	if isServiceFactory(t) {
+		t = t.Out(0)
+		hooks = reflect.Zero(t).Interface()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, RegisterError{fmt.Errorf("service %s: only allow struct type, but receive %s", t.Name(), t.Kind())}
+	}
 	s = new(Service)
-	s.Name = t.Name()
+	s.Name = q.option.PathNaming.service(t.Name())
+	if sn, ok := hooks.(ServiceNamer); ok {
+		s.Name = cleanMount(sn.ServiceName())
+	}
+	if mount != nil {
+		s.Name = cleanMount(*mount)
+	}
+	if g != nil {
+		s.Name = joinMount(g.prefix, s.Name)
+		s.Version = g.version
+		s.middlewares = append(s.middlewares, g.middlewares...)
+	}
+	if s.Name == "" {
+		s.Name = ROOT_SERVICE_NAME
+	}
 	s.ServiceType = t
 	s.quarkInstance = q
-	s.atrie = util.NewTrie()
-	if t.Kind() != reflect.Struct {
-		panic(fmt.Errorf("only allow struct type, but receive [%s-%s]", t.Name(), t.Kind()))
+	if e := s.bindInstance(inst); e != nil {
+		errs = append(errs, e)
+	}
+	if sm, ok := hooks.(ServiceMiddlewares); ok {
+		s.middlewares = append(s.middlewares, sm.Middlewares()...)
+	}
+	if cd, ok := hooks.(CORSDefiner); ok {
+		s.cors = cd.CORS()
+	}
+	configurer, _ := hooks.(ApiConfigurer)
+	if rd, ok := hooks.(RouteDefiner); ok {
+		s.routeOverrides = rd.Routes()
+		for name := range s.routeOverrides {
+			m, ok := t.MethodByName(name)
+			if ok {
+				reserved, _ := reservedMethod(t, m)
+				ok = !reserved
+			}
+			if !ok {
+				errs = append(errs, fmt.Errorf("service %s: route of %s has no api method", s.Name, name))
+			}
+		}
 	}
 	for i := 0; i < t.NumMethod(); i++ {
 		method := t.Method(i)
-		if isConsoleMethod(method) {
+		if reserved, e := reservedMethod(t, method); reserved {
+			if e != nil {
+				errs = append(errs, fmt.Errorf("service %s: %v", s.Name, e))
+			}
 			continue
 		}
 		if api, e := s.newApi(method); e != nil {
-			panic(fmt.Errorf("newApi fail %v", e))
+			errs = append(errs, fmt.Errorf("service %s method %s: %v", s.Name, method.Name, e))
 		} else {
+			if g != nil {
+				api.Deprecation = g.deprecation
+			}
+			if configurer != nil {
+				configurer.ConfigureApi(api)
+			}
 			s.Apis = append(s.Apis, *api)
-			triePath := append(strings.Split(api.Path[1:], "/"), ":"+api.Method)
-			s.atrie.Add(triePath, len(s.Apis)-1)
 		}
 	}
 	return
@@ -504,6 +724,7 @@ func (q *Quark) newService(t reflect.Type) (s *Service) {
 
 func (s *Service) newApi(method reflect.Method) (api *Api, e error) {
 	name := method.Name
+	naming := s.quarkInstance.option.PathNaming
 	api = new(Api)
 	api.serviceInstance = s
 	api.ReflectMethod = method
@@ -511,47 +732,88 @@ func (s *Service) newApi(method reflect.Method) (api *Api, e error) {
 		if methodCandidate := name[:firstUnderlinePos]; validMethods[methodCandidate] {
 			api.Method = methodCandidate
 			name = name[firstUnderlinePos+1:]
+		} else if methodCandidate == websocketMethodPrefix {
+			api.Method = http.MethodGet
+			api.websocket = true
+			name = name[firstUnderlinePos+1:]
 		}
 	}
 	var varsType []reflect.Kind
 	mtype := method.Type
-	switch mtype.NumIn() {
-	case 1: // no input
-	default: // path vars, with or without final request struct
-		for i := 1; i < mtype.NumIn(); i++ {
-			argType := mtype.In(i)
-			argKind := argType.Kind()
-			switch {
-			case argKind == reflect.String:
-				varsType = append(varsType, reflect.String)
-			case reflect.Int <= argKind && argKind <= reflect.Uint64:
-				varsType = append(varsType, reflect.Int)
-			case reflect.Float32 <= argKind && argKind <= reflect.Float64:
-				varsType = append(varsType, reflect.Float64)
-			case argKind == reflect.Struct:
-				if i == mtype.NumIn()-1 {
-					api.Request = argType
-				}
-			default:
-				api, e = nil, fmt.Errorf("newApi invalid func format[%s], path vars only accept number or string type, but appear %v", method.Name, argKind)
+	for i := 1; i < mtype.NumIn(); i++ {
+		argType := mtype.In(i)
+		if kind, ok := injectedArgs[argType]; ok {
+			api.args = append(api.args, kind)
+			continue
+		}
+		if provider, ok := s.quarkInstance.providers[argType]; ok {
+			if api.provided == nil {
+				api.provided = make(map[int]reflect.Value)
+			}
+			api.provided[len(api.args)] = provider
+			api.args = append(api.args, argProvided)
+			continue
+		}
+		argKind := argType.Kind()
+		switch {
+		case argKind == reflect.String:
+			varsType = append(varsType, reflect.String)
+		case reflect.Int <= argKind && argKind <= reflect.Uint64:
+			varsType = append(varsType, reflect.Int)
+		case reflect.Float32 <= argKind && argKind <= reflect.Float64:
+			varsType = append(varsType, reflect.Float64)
+		case argKind == reflect.Struct && api.Request == nil:
+			api.Request = argType
+			api.args = append(api.args, argRequest)
+			continue
+		default:
+			api, e = nil, fmt.Errorf("newApi invalid func format[%s], path vars only accept number or string type, but appear %v", method.Name, argKind)
+			return
+		}
+		api.args = append(api.args, argPathVar)
+	}
+	if route, ok := s.routeOverrides[method.Name]; ok {
+		api.websocket = api.hasArg(argSocket)
+		if api.Method, api.Path, api.PathVars, e = parseRoute(route, varsType); e != nil {
+			api, e = nil, fmt.Errorf("newApi invalid route [%s] of [%s], %v", route, method.Name, e)
+			return
+		}
+		if api.websocket {
+			if api.Method != "" && api.Method != http.MethodGet {
+				api, e = nil, fmt.Errorf("newApi invalid route [%s] of [%s], websocket apis are routed by GET", route, method.Name)
 				return
 			}
+			api.Method = http.MethodGet
 		}
+	} else if api.websocket != api.hasArg(argSocket) {
+		api, e = nil, fmt.Errorf("newApi invalid func format[%s], websocket apis need both WS_ prefix and a quark.Socket parameter", method.Name)
+		return
+	} else {
+		api.Path, api.PathVars, e = util.FuncNameToPathWithNaming(name, varsType, naming.element)
 	}
-	api.Path, api.PathVars, e = util.FuncNameToPathWithVars(name, varsType)
 	if e != nil {
 		e = fmt.Errorf("newApi parse path vars fail, %v", e)
 		return
 	}
-	if mtype.NumOut() > 0 {
-		api.Response = mtype.Out(0)
+	api.errorOut = -1
+	if n := mtype.NumOut(); n > 2 || n == 2 && mtype.Out(1) != errorType {
+		api, e = nil, fmt.Errorf("newApi invalid func format[%s], only a response and a trailing error can be returned", method.Name)
+		return
+	} else if n > 0 {
+		if mtype.Out(n-1) == errorType {
+			api.errorOut = n - 1
+		}
+		if api.errorOut != 0 {
+			api.Response = mtype.Out(0)
+			api.stream = streamKindOf(api.Response)
+		}
 	}
 	api.docMethod = api.Method
 	if api.docMethod == "" {
 		if api.Request != nil {
 			for i := 0; i < api.Request.NumField(); i++ {
 				f := api.Request.Field(i)
-				if !IsUrlType(f.Type) {
+				if !IsUrlType(f.Type) || QuarkTagIn(f) == InForm || IsFileType(f.Type) {
 					api.docMethod = http.MethodPost
 				}
 			}
@@ -564,15 +826,44 @@ func (s *Service) newApi(method reflect.Method) (api *Api, e error) {
 	api.noBody = true
 	if api.Request != nil {
 		api.QueryVars = make(map[string]int)
+		api.HeaderVars = make(map[string]int)
+		api.CookieVars = make(map[string]int)
+		api.FormVars = make(map[string]int)
+		api.FileVars = make(map[string]int)
 		for i := 0; i < api.Request.NumField(); i++ {
 			f := api.Request.Field(i)
-			if IsUrlType(f.Type) {
-				name := QuarkTagOrJsonTagOrSnake(f)
-				api.QueryVars[name] = i
-			} else {
+			in := QuarkTagIn(f)
+			if IsFileType(f.Type) {
+				api.FileVars[naming.field(f)] = i
+				continue
+			}
+			if !IsUrlType(f.Type) {
+				if in != InQuery && in != InBody {
+					api, e = nil, fmt.Errorf("newApi invalid request field [%s.%s], in=%s only accepts url types", method.Name, f.Name, in)
+					return
+				}
 				api.noBody = false
+				continue
+			}
+			name := naming.field(f)
+			switch in {
+			case InQuery:
+				api.QueryVars[name] = i
+			case InHeader:
+				api.HeaderVars[http.CanonicalHeaderKey(name)] = i
+			case InCookie:
+				api.CookieVars[name] = i
+			case InForm:
+				api.FormVars[name] = i
+			default:
+				api, e = nil, fmt.Errorf("newApi invalid request field [%s.%s], unknown in=%s", method.Name, f.Name, in)
+				return
 			}
 		}
+		if api.validators, e = structValidators(api.Request, naming); e != nil {
+			e = fmt.Errorf("newApi invalid quark tag in request of [%s], %v", method.Name, e)
+			return
+		}
 	}
 
 	if len(api.PathVars) == 0 {
@@ -584,6 +875,7 @@ func (s *Service) newApi(method reflect.Method) (api *Api, e error) {
 		}
 		api.docPath = strings.Join(pe, "/")
 	}
+	api.plan = api.compilePlan()
 
 	return
 }
@@ -591,21 +883,33 @@ func (s *Service) newApi(method reflect.Method) (api *Api, e error) {
 var cutset = " \t\n\r"
 
 func QuarkTagOrJsonTagOrSnake(f reflect.StructField) string {
-	if tag := strings.Trim(f.Tag.Get("quark"), cutset); tag != "" {
-		n := strings.Split(tag, ",")[0]
-		if n != "" {
-			return n
+	return PathNaming(nil).field(f)
+}
+
+const (
+	InQuery  = "query" // also read from urlencoded bodies, like r.Form
+	InHeader = "header"
+	InCookie = "cookie"
+	InForm   = "form"
+	InBody   = "body"
+)
+
+// QuarkTagIn returns where a request field comes from by the in option of quark tag, e.g. `quark:"x-request-id,in=header"`,
+// url types default to query and others default to body
+func QuarkTagIn(f reflect.StructField) string {
+	for _, kv := range QuarkTagOptions(f) {
+		if kv[0] == "in" {
+			return strings.ToLower(kv[1])
 		}
 	}
-	if tag := strings.Trim(f.Tag.Get("json"), cutset); tag != "" && tag != "-" {
-		n := strings.Split(tag, ",")[0]
-		if n != "" {
-			return n
-		}
+	if IsUrlType(f.Type) {
+		return InQuery
 	}
-	return util.PascalToSnake(f.Name)
+	return InBody
 }
 
+// PathElementChoices returns the trie keys elem may match in order, the literal one first,
+// then {i}, {f} and {s} when elem is a valid value of them
 func PathElementChoices(elem string) (results []string) {
 	choices := []string{elem, "{i}", "{f}", "{s}"}
 	POS_SAME := 0
@@ -620,13 +924,11 @@ func PathElementChoices(elem string) (results []string) {
 			choices[POS_INT] = ""
 			switch r {
 			case '.':
-				choices[POS_SAME] = ""
 				dotNum += 1
 				if dotNum > 1 {
 					choices[POS_FLOAT] = ""
 				}
-			case '_':
-			default:
+			case '{', '}': // never matches var keys of the trie
 				choices[POS_SAME] = ""
 			}
 		} else if !unicode.IsNumber(r) {
@@ -649,10 +951,13 @@ type TypeAndFormat struct {
 
 var (
 	reservedStructTypes = map[reflect.Type]TypeAndFormat{
-		reflect.TypeOf(time.Time{}): {"string", "date-time"},
-		IntType:                     {"integer", "int64"},
-		NumberType:                  {"number", "double"},
-		StringType:                  {"string", ""},
+		timeType:     {"string", "date-time"},
+		IntType:      {"integer", "int64"},
+		NumberType:   {"number", "double"},
+		StringType:   {"string", ""},
+		BoolType:     {"boolean", ""},
+		TimeType:     {"string", "date-time"},
+		DurationType: {"string", "duration"},
 	}
 	intSchema = spec.Schema{
 		SchemaProps: spec.SchemaProps{
@@ -671,6 +976,11 @@ var (
 			Type: spec.StringOrArray{"string"},
 		},
 	}
+	boolSchema = spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Type: spec.StringOrArray{"boolean"},
+		},
+	}
 )
 
 func (q *Quark) SwaggerSchemaFromType(t reflect.Type, omit_url_parameters bool) (schema spec.Schema) {
@@ -689,13 +999,23 @@ UNWRAP:
 		t = t.Elem()
 	}
 	kind := t.Kind()
+	taf, reserved := reservedStructTypes[t]
 	switch {
+	case reserved:
+		schema = spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type:   spec.StringOrArray{taf.T},
+				Format: taf.F,
+			},
+		}
 	case reflect.Int <= kind && kind <= reflect.Uint64:
 		schema = intSchema
 	case reflect.Float32 <= kind && kind <= reflect.Float64:
 		schema = doubleSchema
 	case reflect.String == kind:
 		schema = stringSchema
+	case reflect.Bool == kind:
+		schema = boolSchema
 	case reflect.Struct == kind:
 		schema = q.SwaggerSchemaFromStruct(t, omit_url_parameters)
 	default:
@@ -724,7 +1044,7 @@ func (q *Quark) SwaggerSchemaFromStruct(t reflect.Type, omit_url_parameters bool
 	schema.Properties = make(spec.SchemaProperties)
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		if omit_url_parameters && IsUrlType(f.Type) {
+		if omit_url_parameters && (IsUrlType(f.Type) || IsFileType(f.Type)) {
 			continue
 		}
 		nullable := false
@@ -733,17 +1053,26 @@ func (q *Quark) SwaggerSchemaFromStruct(t reflect.Type, omit_url_parameters bool
 			typ = typ.Elem()
 			nullable = true
 		}
+		var sub spec.Schema
 		if taf, ok := reservedStructTypes[typ]; ok {
-			schema.Properties[f.Name] = spec.Schema{
+			sub = spec.Schema{
 				SchemaProps: spec.SchemaProps{
 					Type:     spec.StringOrArray{taf.T},
 					Format:   taf.F,
 					Nullable: nullable,
 				},
 			}
-			continue
+		} else {
+			sub = q.SwaggerSchemaFromType(typ, false)
+		}
+		if fr, e := ParseFieldRules(f); e == nil && !fr.Empty() {
+			if sub.Ref.String() == "" {
+				fr.applySchema(&sub, typ)
+			}
+			if fr.Required {
+				schema.Required = append(schema.Required, f.Name)
+			}
 		}
-		var sub spec.Schema = q.SwaggerSchemaFromType(typ, false)
 		schema.Properties[f.Name] = sub
 	}
 	return
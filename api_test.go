@@ -1,7 +1,11 @@
 package quark
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"unicode"
 )
@@ -52,3 +56,316 @@ func TestPathElementChoices(t *testing.T) {
 	t.Log(PathElementChoices("123.5"))
 	//t.Log(PathElementChoices("中国abc"))
 }
+
+type errorService struct {
+	Console
+}
+
+func (s errorService) Find_id(id int) (rsp struct {
+	Id int
+}, e error) {
+	if id == 0 {
+		e = NewError(http.StatusNotFound, "item %d not found", id)
+		return
+	}
+	if id > 100 {
+		e = errors.New("id out of range")
+		return
+	}
+	rsp.Id = id
+	return
+}
+
+func (s errorService) Check() error {
+	return nil
+}
+
+func TestApiError(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(errorService{})
+	Expect := func(path string, status int, body string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status || w.Body.String() != body {
+			t.Errorf("%s expects %d \"%s\" but actual %d \"%s\"", path, status, body, w.Code, w.Body.String())
+		}
+	}
+	Expect("/errorService/find/1", http.StatusOK, `{"Id":1}`)
+	Expect("/errorService/find/0", http.StatusNotFound, `{"detail":"item 0 not found","instance":"/errorService/find/0","status":404,"title":"Not Found","type":"about:blank"}`)
+	Expect("/errorService/find/101", http.StatusInternalServerError, `{"detail":"id out of range","instance":"/errorService/find/101","status":500,"title":"Internal Server Error","type":"about:blank"}`)
+	Expect("/errorService/check", http.StatusOK, "")
+
+	q.WithErrorEncoder(func(c *Console, e error) {
+		c.ResponseWriter().WriteHeader(ErrorStatus(e))
+		c.ResponseWriter().Write([]byte("custom: " + e.Error()))
+	})
+	Expect("/errorService/find/0", http.StatusNotFound, "custom: item 0 not found")
+
+	op := q.Services[0].Apis[1].SwaggerOperations()
+	if op.Responses.Default == nil {
+		t.Errorf("api returning error should document default response")
+	}
+	if _, ok := op.Responses.StatusCodeResponses[http.StatusBadRequest]; !ok {
+		t.Errorf("api with path vars should document 400 response")
+	}
+}
+
+type queryService struct {
+	Console
+}
+
+func (s queryService) Search(req struct {
+	Ids    []Int
+	Names  []String `quark:"name"`
+	Active Bool
+	Since  *Time
+	Within Duration
+}) string {
+	return Js([]interface{}{req.Ids, req.Names, req.Active, req.Since, req.Within.String()})
+}
+
+func TestQueryTypes(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(queryService{})
+	Expect := func(query string, status int, body string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/queryService/search?"+query, nil))
+		if w.Code != status || (status == http.StatusOK && w.Body.String() != body) {
+			t.Errorf("%s expects %d %s but actual %d %s", query, status, body, w.Code, w.Body.String())
+		}
+	}
+	Expect("ids=1&ids=2&name=a&active=true&since=2021-06-01T08:00:00Z&within=1h30m", http.StatusOK,
+		`"[[1,2],[\"a\"],true,\"2021-06-01T08:00:00Z\",\"1h30m0s\"]"`)
+	Expect("", http.StatusOK, `"[null,null,false,null,\"0s\"]"`)
+	Expect("ids=1&ids=x", http.StatusBadRequest, "")
+	Expect("active=yes", http.StatusBadRequest, "")
+	Expect("since=yesterday", http.StatusBadRequest, "")
+
+	q.SwaggerSpec()
+	op := q.Services[0].Apis[0].SwaggerOperations()
+	params := make(map[string]string)
+	for _, p := range op.Parameters {
+		params[p.Name] = p.Type + "/" + p.Format + "/" + p.CollectionFormat
+		if p.Items != nil {
+			params[p.Name] += "/" + p.Items.Type
+		}
+	}
+	expects := map[string]string{
+		"ids":    "array//multi/integer",
+		"name":   "array//multi/string",
+		"active": "boolean//",
+		"since":  "string/date-time/",
+		"within": "string/duration/",
+	}
+	for k, v := range expects {
+		if params[k] != v {
+			t.Errorf("parameter %s expects %s but actual %s", k, v, params[k])
+		}
+	}
+}
+
+type sourceService struct {
+	Console
+}
+
+func (s sourceService) POST_Submit(req struct {
+	RequestId String   `quark:"x-request-id,in=header,required"`
+	Tags      []String `quark:"x-tag,in=header"`
+	Session   *String  `quark:"session,in=cookie"`
+	Title     String   `quark:"title,in=form"`
+	Count     Int      `quark:"count,in=form"`
+	Page      Int      `quark:"page"`
+}) string {
+	session := ""
+	if req.Session != nil {
+		session = req.Session.V()
+	}
+	return Js([]interface{}{req.RequestId, req.Tags, session, req.Title, req.Count, req.Page})
+}
+
+func TestParameterSources(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(sourceService{})
+	r := httptest.NewRequest(http.MethodPost, "/sourceService/submit?page=2&title=ignored", strings.NewReader("title=hello&count=3"))
+	r.Header.Set("Content-Type", MediaTypeForm)
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Add("X-Tag", "a")
+	r.Header.Add("X-Tag", "b")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if expect := `"[\"abc\",[\"a\",\"b\"],\"s1\",\"hello\",3,2]"`; w.Code != http.StatusOK || w.Body.String() != expect {
+		t.Errorf("expects %s but actual %d %s", expect, w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/sourceService/submit", nil)
+	w = httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing required header expects 400 but actual %d", w.Code)
+	}
+
+	q.SwaggerSpec()
+	op := q.Services[0].Apis[0].SwaggerOperations()
+	ins := make(map[string]string)
+	for _, p := range op.Parameters {
+		ins[p.Name] = p.In
+	}
+	expects := map[string]string{"x-request-id": "header", "x-tag": "header", "session": "cookie", "title": "formData", "count": "formData", "page": "query"}
+	for k, v := range expects {
+		if ins[k] != v {
+			t.Errorf("parameter %s expects in %s but actual %s", k, v, ins[k])
+		}
+	}
+	if len(op.Consumes) != 2 || op.Consumes[0] != MediaTypeForm || op.Consumes[1] != MediaTypeMultipart {
+		t.Errorf("form parameters expect consumes %s and %s but actual %v", MediaTypeForm, MediaTypeMultipart, op.Consumes)
+	}
+}
+
+type loginService struct {
+	Console
+}
+
+func (s loginService) POST_Login(req struct {
+	User String
+	Lang String
+}) string {
+	return string(req.User) + " " + string(req.Lang)
+}
+
+func TestQueryFromUrlencodedBody(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(loginService{})
+	r := httptest.NewRequest(http.MethodPost, "/loginService/login?lang=en", strings.NewReader("user=bob"))
+	r.Header.Set("Content-Type", MediaTypeForm)
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `"bob en"` {
+		t.Errorf("expects query fields bound from the urlencoded body but actual %d %s", w.Code, w.Body.String())
+	}
+}
+
+type badService struct {
+	Console
+}
+
+func (s badService) Item_() {}
+
+func (s badService) Find_id_name(id int) {}
+
+func (s badService) Take(ch chan int) {}
+
+func (s badService) Pair() (int, int) { return 0, 0 }
+
+func (s badService) Fine() {}
+
+func TestRegisterError(t *testing.T) {
+	q := NewQuark()
+	e := q.Register(badService{}, 1)
+	errs, ok := e.(RegisterError)
+	if !ok || len(errs) != 5 {
+		t.Fatalf("expects 5 problems but actual %v", e)
+	}
+	for i, expect := range []string{
+		"service badService method Find_id_name: ",
+		"service badService method Item_: ",
+		"service badService method Pair: ",
+		"service badService method Take: ",
+		"service int: only allow struct type",
+	} {
+		if !strings.HasPrefix(errs[i].Error(), expect) {
+			t.Errorf("expects %s but actual %v", expect, errs[i])
+		}
+	}
+	if len(q.Services) != 0 {
+		t.Errorf("expects nothing registered but actual %d services", len(q.Services))
+	}
+	if e = q.Register(errorService{}, errorService{}); e == nil || !strings.Contains(e.Error(), "registered twice") {
+		t.Errorf("expects the service registered twice but actual %v", e)
+	}
+}
+
+type benchService struct {
+	Console
+}
+
+type benchItem struct {
+	Id    int
+	Name  string
+	Price float64
+}
+
+func (s benchService) Item_id(id int) benchItem {
+	return benchItem{Id: id, Name: "item"}
+}
+
+func (s benchService) Search(req struct {
+	Keyword String
+	Page    Int
+	Size    *Int
+	Tags    []String
+	Trace   String `quark:"x-trace-id,in=header"`
+}) []benchItem {
+	return []benchItem{{Id: int(req.Page.V()), Name: req.Keyword.V()}}
+}
+
+func (s benchService) POST_Item(req benchItem) (benchItem, error) {
+	return req, nil
+}
+
+// benchWriter is a reused ResponseWriter, so benchmarks count the allocations of quark only
+type benchWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *benchWriter) Header() http.Header         { return w.header }
+func (w *benchWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *benchWriter) WriteHeader(status int)      { w.status = status }
+
+// benchBody is a reused request body
+type benchBody struct {
+	strings.Reader
+	body string
+}
+
+func (b *benchBody) Close() error { return nil }
+
+func benchmarkServe(b *testing.B, method, target, body string, header http.Header) {
+	q := NewQuark()
+	q.RegisterService(benchService{})
+	r := httptest.NewRequest(method, target, nil)
+	for k, vs := range header {
+		r.Header[k] = vs
+	}
+	rb := &benchBody{body: body}
+	w := &benchWriter{header: make(http.Header)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Reset(rb.body)
+		r.Body, r.Form, r.PostForm = rb, nil, nil
+		for k := range w.header {
+			delete(w.header, k)
+		}
+		w.status = 0
+		q.ServeHTTP(w, r)
+		if w.status != http.StatusOK {
+			b.Fatalf("%s %s answered %d", method, target, w.status)
+		}
+	}
+}
+
+func BenchmarkServePathVar(b *testing.B) {
+	benchmarkServe(b, http.MethodGet, "/benchService/item/42", "", nil)
+}
+
+func BenchmarkServeQuery(b *testing.B) {
+	benchmarkServe(b, http.MethodGet, "/benchService/search?keyword=car&page=2&size=20&tags=a&tags=b", "",
+		http.Header{"X-Trace-Id": {"t1"}})
+}
+
+func BenchmarkServeJsonBody(b *testing.B) {
+	benchmarkServe(b, http.MethodPost, "/benchService/item", `{"id":42,"name":"item","price":9.5}`,
+		http.Header{"Content-Type": {MediaTypeJson}})
+}
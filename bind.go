@@ -0,0 +1,272 @@
+package quark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// argKind tells how a method parameter is filled
+type argKind int
+
+const (
+	argPathVar argKind = iota
+	argRequest
+	argBody        // io.Reader of the unbuffered request body
+	argEventStream // EventStream of server-sent events
+	argSocket      // Socket of an upgraded websocket connection
+	argContext     // context.Context of the request
+	argProvided    // value of a provider registered by Quark.Provide
+)
+
+var (
+	readerType     = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+
+	// injectedArgs are parameter types filled by Quark, they don't take part in path vars
+	injectedArgs = map[reflect.Type]argKind{
+		readerType:      argBody,
+		readCloserType:  argBody,
+		eventStreamType: argEventStream,
+		socketType:      argSocket,
+		contextType:     argContext,
+	}
+)
+
+func (a *Api) hasArg(kind argKind) bool {
+	for _, k := range a.args {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// bindPlan is compiled by newApi, so serving a request binds arguments without inspecting types again,
+// see the BenchmarkServe* benchmarks for the allocations per request
+type bindPlan struct {
+	readsBody bool            // the handler reads the unbuffered body itself
+	parseForm bool            // form fields are bound, so the buffered body is parsed as a form
+	pathVars  []pathVarBinder // in the order of path var parameters
+	query     []fieldBinder
+	header    []fieldBinder
+	cookie    []fieldBinder
+	form      []fieldBinder
+	files     []fieldBinder
+	presence  bool         // some bound field has rules, so bindRequest records which fields are supplied
+	chain     atomic.Value // *handlerChain
+	ins       sync.Pool    // *[]reflect.Value of method arguments
+}
+
+// pathVarBinder parses the index-th path var into a method parameter of typ
+type pathVarBinder struct {
+	index int
+	typ   reflect.Type
+}
+
+// fieldBinder sets the values of name into the request struct field at index
+type fieldBinder struct {
+	name  string
+	index int
+	set   urlSetter
+}
+
+func (a *Api) compilePlan() *bindPlan {
+	p := &bindPlan{readsBody: a.hasArg(argBody)}
+	pathVarIndex := 0
+	for i, kind := range a.args {
+		if kind == argPathVar {
+			p.pathVars = append(p.pathVars, pathVarBinder{pathVarIndex, a.ReflectMethod.Type.In(i + 1)})
+			pathVarIndex++
+		}
+	}
+	fields := func(vars map[string]int) (binders []fieldBinder) {
+		for name, index := range vars {
+			binders = append(binders, fieldBinder{name, index, newUrlSetter(a.Request.Field(index).Type)})
+		}
+		sort.Slice(binders, func(i, j int) bool { return binders[i].index < binders[j].index })
+		return
+	}
+	p.query = fields(a.QueryVars)
+	p.header = fields(a.HeaderVars)
+	p.cookie = fields(a.CookieVars)
+	p.form = fields(a.FormVars)
+	for name, index := range a.FileVars {
+		p.files = append(p.files, fieldBinder{name: name, index: index})
+	}
+	p.parseForm = len(p.form) > 0
+	bound := make(map[int]bool)
+	for _, binders := range [][]fieldBinder{p.query, p.header, p.cookie, p.form, p.files} {
+		for _, f := range binders {
+			bound[f.index] = true
+		}
+	}
+	for i := range a.validators {
+		if fv := &a.validators[i]; len(fv.index) == 1 && bound[fv.index[0]] {
+			fv.bound, p.presence = true, true
+		}
+	}
+	n := 1 + len(a.args)
+	p.ins.New = func() interface{} {
+		in := make([]reflect.Value, n)
+		return &in
+	}
+	return p
+}
+
+// arguments returns a slice for the receiver and the parameters, release it once the method returns
+func (p *bindPlan) arguments() *[]reflect.Value {
+	return p.ins.Get().(*[]reflect.Value)
+}
+
+func (p *bindPlan) release(in *[]reflect.Value) {
+	for i := range *in {
+		(*in)[i] = reflect.Value{}
+	}
+	p.ins.Put(in)
+}
+
+// bind parses the path var into its parameter, numbers out of the range of the parameter type are rejected
+func (b pathVarBinder) bind(c *Console) reflect.Value {
+	arg := c.pathVars[b.index]
+	argV := reflect.New(b.typ).Elem()
+	switch kind := b.typ.Kind(); {
+	case kind == reflect.String:
+		argV.SetString(arg)
+	case reflect.Int <= kind && kind <= reflect.Int64:
+		i, e := strconv.ParseInt(arg, 10, b.typ.Bits())
+		if e != nil {
+			c.Halt(http.StatusBadRequest, e)
+		}
+		argV.SetInt(i)
+	case reflect.Uint <= kind && kind <= reflect.Uint64:
+		u, e := strconv.ParseUint(arg, 10, b.typ.Bits())
+		if e != nil {
+			c.Halt(http.StatusBadRequest, e)
+		}
+		argV.SetUint(u)
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		f, e := strconv.ParseFloat(arg, b.typ.Bits())
+		if e != nil {
+			c.Halt(http.StatusBadRequest, e)
+		}
+		argV.SetFloat(f)
+	}
+	return argV
+}
+
+// bindRequest fills the request struct from body, query, header, cookie, form and files, then validates it
+func (a *Api) bindRequest(c *Console) reflect.Value {
+	r := c.r
+	p := a.plan
+	reqV := reflect.New(a.Request)
+	if !a.noBody && len(c.body) > 0 {
+		_, decoder, ok := a.Service().Quark().decoder(r.Header.Get("Content-Type"))
+		if !ok {
+			c.Halt(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %s", r.Header.Get("Content-Type")))
+		}
+		if e := decoder.Unmarshal(c.body, reqV.Interface()); e != nil {
+			if errors.Is(e, ErrUnsupportedType) {
+				c.Halt(http.StatusUnsupportedMediaType, e)
+			}
+			c.Halt(http.StatusBadRequest, e)
+		}
+	}
+	req := reqV.Elem()
+	var supplied []bool
+	if p.presence {
+		supplied = make([]bool, req.NumField())
+	}
+	set := func(f fieldBinder, vs []string) error {
+		if supplied != nil {
+			supplied[f.index] = !blankValues(vs)
+		}
+		return f.set(req.Field(f.index), vs)
+	}
+	if len(p.query) > 0 {
+		query := r.Form // urlencoded bodies fill query fields too, their values come before the ones of the url
+		if query == nil {
+			query = r.URL.Query()
+		}
+		for _, f := range p.query {
+			if e := set(f, query[f.name]); e != nil {
+				c.Halt(http.StatusBadRequest, fmt.Errorf("Failed to parse query parameter %s %v", f.name, e))
+			}
+		}
+	}
+	for _, f := range p.header {
+		if e := set(f, r.Header[f.name]); e != nil {
+			c.Halt(http.StatusBadRequest, fmt.Errorf("Failed to parse header %s %v", f.name, e))
+		}
+	}
+	if len(p.cookie) > 0 {
+		cookies := r.Cookies()
+		for _, f := range p.cookie {
+			if e := set(f, cookieValues(cookies, f.name)); e != nil {
+				c.Halt(http.StatusBadRequest, fmt.Errorf("Failed to parse cookie %s %v", f.name, e))
+			}
+		}
+	}
+	for _, f := range p.form {
+		if e := set(f, r.PostForm[f.name]); e != nil {
+			c.Halt(http.StatusBadRequest, fmt.Errorf("Failed to parse form field %s %v", f.name, e))
+		}
+	}
+	if r.MultipartForm != nil {
+		for _, f := range p.files {
+			fhs := r.MultipartForm.File[f.name]
+			if supplied != nil {
+				supplied[f.index] = len(fhs) > 0
+			}
+			SetFileValue(req.Field(f.index), fhs)
+		}
+	}
+	if e := validate(req, a.validators, supplied); e != nil {
+		c.Halt(http.StatusBadRequest, e)
+	}
+	return req
+}
+
+// blankValues tells whether url values are absent, a single blank value counts as absent like in SetUrlValue
+func blankValues(vs []string) bool {
+	return len(vs) == 0 || (len(vs) == 1 && vs[0] == "")
+}
+
+func cookieValues(cookies []*http.Cookie, name string) (vs []string) {
+	for _, c := range cookies {
+		if c.Name == name {
+			vs = append(vs, c.Value)
+		}
+	}
+	return
+}
+
+var bodyBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBody is the largest buffer kept by bodyBuffers, bigger ones are left to the GC
+const maxPooledBody = 64 << 10
+
+// readBody reads body through a pooled buffer and returns a copy of exactly its size, nil when it's empty
+func readBody(body io.Reader) ([]byte, error) {
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBody {
+			buf.Reset()
+			bodyBuffers.Put(buf)
+		}
+	}()
+	if _, e := buf.ReadFrom(body); e != nil {
+		return nil, e
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
@@ -0,0 +1,439 @@
+package quark
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MediaTypeJson      = "application/json"
+	MediaTypeXml       = "application/xml"
+	MediaTypeTextXml   = "text/xml"
+	MediaTypeYaml      = "application/yaml"
+	MediaTypeXYaml     = "application/x-yaml"
+	MediaTypeMsgpack   = "application/msgpack"
+	MediaTypeXMsgpack  = "application/x-msgpack"
+	MediaTypeForm      = "application/x-www-form-urlencoded"
+	MediaTypeProtobuf  = "application/protobuf"
+	MediaTypeXProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes responses and decodes request bodies of one media type
+type Codec interface {
+	Marshal(x interface{}) ([]byte, error)
+	Unmarshal(b []byte, x interface{}) error
+}
+
+// TypedCodec is implemented by codecs which only handle some types,
+// swagger consumes and produces of an api only list codecs accepting its types
+type TypedCodec interface {
+	Codec
+	Accepts(t reflect.Type) bool
+}
+
+var (
+	// ErrUnsupportedType is returned by codecs which can't handle the given value, it turns into 415 or 406
+	ErrUnsupportedType = errors.New("type is not supported by the codec")
+
+	msgpackMarshalerType   = reflect.TypeOf((*MsgpackMarshaler)(nil)).Elem()
+	msgpackUnmarshalerType = reflect.TypeOf((*MsgpackUnmarshaler)(nil)).Elem()
+	protoMarshalerType     = reflect.TypeOf((*ProtoMarshaler)(nil)).Elem()
+	protoUnmarshalerType   = reflect.TypeOf((*ProtoUnmarshaler)(nil)).Elem()
+)
+
+type funcCodec struct {
+	marshal   JsonMarshalFunc
+	unmarshal JsonUnmarshalFunc
+}
+
+func (c funcCodec) Marshal(x interface{}) ([]byte, error) {
+	return c.marshal(x)
+}
+
+func (c funcCodec) Unmarshal(b []byte, x interface{}) error {
+	return c.unmarshal(b, x)
+}
+
+// NewCodec makes a Codec from a pair of functions
+func NewCodec(marshal JsonMarshalFunc, unmarshal JsonUnmarshalFunc) Codec {
+	return funcCodec{marshal, unmarshal}
+}
+
+// jsonCodec follows Quark.Marshal and Quark.Unmarshal, so replacing them still works
+type jsonCodec struct {
+	q *Quark
+}
+
+func (c jsonCodec) Marshal(x interface{}) ([]byte, error) {
+	return c.q.Marshal(x)
+}
+
+func (c jsonCodec) Unmarshal(b []byte, x interface{}) error {
+	return c.q.Unmarshal(b, x)
+}
+
+// xmlCodec wraps values without an element name, e.g. anonymous structs or slices, in <response>
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(x interface{}) ([]byte, error) {
+	t := reflect.TypeOf(x)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() != "" && t.Kind() != reflect.Slice {
+		return xml.Marshal(x)
+	}
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	if e := enc.EncodeElement(x, xml.StartElement{Name: xml.Name{Local: "response"}}); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+func (xmlCodec) Unmarshal(b []byte, x interface{}) error {
+	return xml.Unmarshal(b, x)
+}
+
+// MsgpackMarshaler and MsgpackUnmarshaler follow the methods generated by tinylib/msgp
+type MsgpackMarshaler interface {
+	MarshalMsg(b []byte) ([]byte, error)
+}
+
+type MsgpackUnmarshaler interface {
+	UnmarshalMsg(b []byte) ([]byte, error)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Accepts(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(msgpackMarshalerType) || pt.Implements(msgpackUnmarshalerType)
+}
+
+func (msgpackCodec) Marshal(x interface{}) ([]byte, error) {
+	if m, ok := x.(MsgpackMarshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	if m, ok := addressable(x).(MsgpackMarshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	return nil, ErrUnsupportedType
+}
+
+func (msgpackCodec) Unmarshal(b []byte, x interface{}) error {
+	if m, ok := x.(MsgpackUnmarshaler); ok {
+		_, e := m.UnmarshalMsg(b)
+		return e
+	}
+	return ErrUnsupportedType
+}
+
+// ProtoMarshaler and ProtoUnmarshaler are implemented by gogo/protobuf and similar generated messages
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+	Unmarshal(b []byte) error
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Accepts(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(protoMarshalerType) || pt.Implements(protoUnmarshalerType)
+}
+
+func (protobufCodec) Marshal(x interface{}) ([]byte, error) {
+	if m, ok := x.(ProtoMarshaler); ok {
+		return m.Marshal()
+	}
+	if m, ok := addressable(x).(ProtoMarshaler); ok {
+		return m.Marshal()
+	}
+	return nil, ErrUnsupportedType
+}
+
+func (protobufCodec) Unmarshal(b []byte, x interface{}) error {
+	if m, ok := x.(ProtoUnmarshaler); ok {
+		return m.Unmarshal(b)
+	}
+	return ErrUnsupportedType
+}
+
+// addressable returns a pointer to a copy of x, so methods with pointer receivers are reachable
+func addressable(x interface{}) interface{} {
+	v := reflect.ValueOf(x)
+	if !v.IsValid() || v.Kind() == reflect.Ptr {
+		return x
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.Interface()
+}
+
+// formCodec maps top level struct fields to url encoded values, fields are named like query vars
+type formCodec struct {
+	q *Quark
+}
+
+func (formCodec) Accepts(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func (c formCodec) Marshal(x interface{}) ([]byte, error) {
+	v := reflect.ValueOf(x)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, ErrUnsupportedType
+	}
+	values := url.Values{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		name := c.q.option.PathNaming.field(f)
+		switch {
+		case fv.Kind() == reflect.Ptr:
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8:
+			for j := 0; j < fv.Len(); j++ {
+				values.Add(name, fmt.Sprint(fv.Index(j).Interface()))
+			}
+		case isFormScalar(fv.Kind()):
+			values.Set(name, fmt.Sprint(fv.Interface()))
+		default:
+			return nil, ErrUnsupportedType
+		}
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (c formCodec) Unmarshal(b []byte, x interface{}) error {
+	values, e := url.ParseQuery(string(b))
+	if e != nil {
+		return e
+	}
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return ErrUnsupportedType
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		vs, ok := values[c.q.option.PathNaming.field(f)]
+		if !ok {
+			vs, ok = values[f.Name]
+		}
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if e := setFormValue(v.Field(i), vs); e != nil {
+			return fmt.Errorf("form field %s, %v", f.Name, e)
+		}
+	}
+	return nil
+}
+
+func isFormScalar(kind reflect.Kind) bool {
+	return kind == reflect.String || kind == reflect.Bool ||
+		reflect.Int <= kind && kind <= reflect.Float64
+}
+
+func setFormValue(v reflect.Value, vs []string) error {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		return setFormValue(v.Elem(), vs)
+	}
+	if v.Kind() == reflect.Slice {
+		s := reflect.MakeSlice(v.Type(), len(vs), len(vs))
+		for i := range vs {
+			if e := setFormValue(s.Index(i), vs[i:i+1]); e != nil {
+				return e
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	s := vs[0]
+	kind := v.Kind()
+	switch {
+	case kind == reflect.String:
+		v.SetString(s)
+	case kind == reflect.Bool:
+		b, e := strconv.ParseBool(s)
+		if e != nil {
+			return e
+		}
+		v.SetBool(b)
+	case reflect.Int <= kind && kind <= reflect.Int64:
+		i, e := strconv.ParseInt(s, 10, 64)
+		if e != nil {
+			return e
+		}
+		v.SetInt(i)
+	case reflect.Uint <= kind && kind <= reflect.Uintptr:
+		u, e := strconv.ParseUint(s, 10, 64)
+		if e != nil {
+			return e
+		}
+		v.SetUint(u)
+	case reflect.Float32 == kind || reflect.Float64 == kind:
+		f, e := strconv.ParseFloat(s, 64)
+		if e != nil {
+			return e
+		}
+		v.SetFloat(f)
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// codecs keeps codecs by media type in registration order, the first one is the default
+type codecs struct {
+	types []string
+	m     map[string]Codec
+}
+
+func (cs *codecs) register(mediaType string, c Codec) {
+	if cs.m == nil {
+		cs.m = make(map[string]Codec)
+	}
+	mediaType = strings.ToLower(mediaType)
+	if _, exists := cs.m[mediaType]; !exists {
+		cs.types = append(cs.types, mediaType)
+	}
+	cs.m[mediaType] = c
+}
+
+func (q *Quark) registerDefaultCodecs() {
+	q.codecs.register(MediaTypeJson, jsonCodec{q})
+	q.codecs.register(MediaTypeXml, xmlCodec{})
+	q.codecs.register(MediaTypeTextXml, xmlCodec{})
+	q.codecs.register(MediaTypeYaml, NewCodec(yaml.Marshal, yaml.Unmarshal))
+	q.codecs.register(MediaTypeXYaml, NewCodec(yaml.Marshal, yaml.Unmarshal))
+	q.codecs.register(MediaTypeForm, formCodec{q})
+	q.codecs.register(MediaTypeMsgpack, msgpackCodec{})
+	q.codecs.register(MediaTypeXMsgpack, msgpackCodec{})
+	q.codecs.register(MediaTypeProtobuf, protobufCodec{})
+	q.codecs.register(MediaTypeXProtobuf, protobufCodec{})
+}
+
+// RegisterCodec adds or replaces the codec of mediaType
+func (q *Quark) RegisterCodec(mediaType string, c Codec) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.codecs.register(mediaType, c)
+	q.swagger, q.versionSwaggers, q.docsCache = nil, nil, nil
+}
+
+// MediaTypes returns registered media types, the default one first
+func (q *Quark) MediaTypes() []string {
+	return append([]string{}, q.codecs.types...)
+}
+
+// mediaTypesFor returns media types whose codecs can handle t
+func (q *Quark) mediaTypesFor(t reflect.Type) (types []string) {
+	for _, mt := range q.codecs.types {
+		if tc, ok := q.codecs.m[mt].(TypedCodec); ok && !tc.Accepts(t) {
+			continue
+		}
+		types = append(types, mt)
+	}
+	return
+}
+
+// decoder picks the codec by Content-Type, blank means the default one
+func (q *Quark) decoder(contentType string) (mediaType string, c Codec, ok bool) {
+	if contentType == "" {
+		mediaType = q.codecs.types[0]
+		return mediaType, q.codecs.m[mediaType], true
+	}
+	mediaType, _, e := mime.ParseMediaType(contentType)
+	if e != nil {
+		return
+	}
+	c, ok = q.codecs.m[mediaType]
+	return
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+// encoder picks the codec by Accept with quality values, blank or */* means the default one
+func (q *Quark) encoder(accept string) (mediaType string, c Codec, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		mediaType = q.codecs.types[0]
+		return mediaType, q.codecs.m[mediaType], true
+	}
+	var ranges []acceptRange
+	for i, s := range strings.Split(accept, ",") {
+		mt, params, e := mime.ParseMediaType(strings.TrimSpace(s))
+		if e != nil {
+			continue
+		}
+		ar := acceptRange{mt, 1, i}
+		if qs, exists := params["q"]; exists {
+			if qv, e := strconv.ParseFloat(qs, 64); e == nil {
+				ar.q = qv
+			}
+		}
+		if ar.q > 0 {
+			ranges = append(ranges, ar)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, ar := range ranges {
+		for _, mt := range q.codecs.types {
+			if matchMediaRange(ar.mediaType, mt) {
+				return mt, q.codecs.m[mt], true
+			}
+		}
+	}
+	return
+}
+
+func matchMediaRange(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+	}
+	return false
+}
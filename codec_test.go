@@ -0,0 +1,66 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type codecService struct {
+	Console
+}
+
+type Greeting struct {
+	Name  string `json:"name" yaml:"name" xml:"name"`
+	Times int    `json:"times" yaml:"times" xml:"times"`
+}
+
+func (s codecService) POST_Greet(req Greeting) (rsp Greeting) {
+	rsp.Name = "hello " + req.Name
+	rsp.Times = req.Times + 1
+	return
+}
+
+func TestCodec(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(codecService{})
+	Expect := func(contentType, accept, body string, status int, rspType, rspBody string) {
+		r := httptest.NewRequest(http.MethodPost, "/codecService/greet", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("[%s -> %s] expects status %d but actual %d", contentType, accept, status, w.Code)
+			return
+		}
+		if status != http.StatusOK {
+			return
+		}
+		if actual := w.Header().Get("Content-Type"); actual != rspType {
+			t.Errorf("[%s -> %s] expects Content-Type %s but actual %s", contentType, accept, rspType, actual)
+		}
+		if actual := strings.TrimSpace(w.Body.String()); actual != rspBody {
+			t.Errorf("[%s -> %s] expects body %s but actual %s", contentType, accept, rspBody, actual)
+		}
+	}
+	Expect("", "", `{"name":"bob","times":1}`, http.StatusOK, MediaTypeJson, `{"name":"hello bob","times":2}`)
+	Expect(MediaTypeJson+"; charset=utf-8", "application/xml;q=0.5, application/yaml", `{"name":"bob"}`, http.StatusOK,
+		MediaTypeYaml, "name: hello bob\ntimes: 1")
+	Expect(MediaTypeXml, "text/*", `<Greeting><name>bob</name></Greeting>`, http.StatusOK,
+		MediaTypeTextXml, `<Greeting><name>hello bob</name><times>1</times></Greeting>`)
+	Expect(MediaTypeForm, "*/*", `name=bob&times=3`, http.StatusOK, MediaTypeJson, `{"name":"hello bob","times":4}`)
+	Expect(MediaTypeForm, MediaTypeForm, `name=bob&times=3`, http.StatusOK, MediaTypeForm, `name=hello+bob&times=4`)
+	Expect("text/csv", "", `bob,1`, http.StatusUnsupportedMediaType, "", "")
+	Expect(MediaTypeProtobuf, "", `bob`, http.StatusUnsupportedMediaType, "", "")
+	Expect("", "image/png", `{}`, http.StatusNotAcceptable, "", "")
+	Expect("", MediaTypeMsgpack, `{}`, http.StatusNotAcceptable, "", "")
+
+	op := q.SwaggerSpec().Paths.Paths["/codecService/greet"].Post
+	for _, types := range [][]string{op.Consumes, op.Produces} {
+		if strings.Join(types, ",") != "application/json,application/xml,text/xml,application/yaml,application/x-yaml,application/x-www-form-urlencoded" {
+			t.Errorf("unexpected media types %v", types)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package quark
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"runtime/debug"
 	"sync"
 )
 
@@ -12,34 +14,51 @@ var (
 	consoleMethodMap  map[string]reflect.Method
 	consoleMethodLock sync.Mutex
 	consoleType       = reflect.TypeOf(Console{})
-	haltPanicType     = reflect.TypeOf(haltPanic{})
 )
 
 type Console struct {
-	w       http.ResponseWriter
-	r       *http.Request
-	body    []byte
-	quark   *Quark
-	context interface{}
+	w         *responseWriter
+	r         *http.Request
+	body      []byte
+	quark     *Quark
+	api       *Api
+	pathVars  []string    // values of the path vars in order
+	store     *valueStore // shared by all copies of the Console, so values set in middlewares reach the receiver
+	mediaType string      // negotiated response media type
+	codec     Codec
 }
 
 func NewConsole(w http.ResponseWriter, r *http.Request, body []byte) *Console {
 	return &Console{
-		w:    w,
-		r:    r,
-		body: body,
+		w:     &responseWriter{ResponseWriter: w},
+		r:     r,
+		body:  body,
+		store: newValueStore(),
 	}
 }
 
+// Halt stops the api with status, errors are written as application/problem+json with status,
+// other values are marshalled by the negotiated codec, nil writes the status only
 func (c Console) Halt(status int, e interface{}) {
 	if e != nil {
 		var b []byte
 		switch v := e.(type) {
 		case error:
-			b = []byte(fmt.Sprintf("%v", v))
+			p := *ProblemOf(v)
+			if p.Status != status {
+				p.Status, p.Title = status, http.StatusText(status)
+			}
+			if p.Instance == "" && c.r != nil {
+				p.Instance = c.r.URL.Path
+			}
+			b, _ = json.Marshal(&p)
+			panic(haltPanic{status, b, MediaTypeProblemJson})
 		default:
 			marshal := json.Marshal
-			if c.quark != nil {
+			contentType := MediaTypeJson
+			if c.codec != nil {
+				marshal, contentType = c.codec.Marshal, c.mediaType
+			} else if c.quark != nil {
 				marshal = c.quark.Marshal
 			}
 			var err error
@@ -47,8 +66,8 @@ func (c Console) Halt(status int, e interface{}) {
 			if err != nil {
 				panic(fmt.Errorf("Halt marshal error, %v", err))
 			}
+			panic(haltPanic{status, b, contentType})
 		}
-		panic(haltPanic{status, b})
 	}
 	panic(haltPanic{Status: status})
 }
@@ -58,30 +77,78 @@ func (c Console) Request() *http.Request {
 }
 
 func (c Console) ResponseWriter() http.ResponseWriter {
+	if c.w == nil {
+		return nil
+	}
 	return c.w
 }
 
+// ResponseStatus returns the status code written so far, 0 means nothing is written yet
+func (c Console) ResponseStatus() int {
+	if c.w == nil {
+		return 0
+	}
+	return c.w.status
+}
+
+// CurrentApi returns the api being served, nil if the Console is not created by Quark
+func (c Console) CurrentApi() *Api {
+	return c.api
+}
+
 func (c Console) Body() []byte {
 	return c.body
 }
 
 type haltPanic struct {
-	Status int
-	Body   []byte
+	Status      int
+	Body        []byte
+	ContentType string
 }
 
-func isConsoleMethod(m reflect.Method) bool {
+// apiPanic carries the stack of a non-halt panic raised inside a Handler
+type apiPanic struct {
+	Value interface{}
+	Stack []byte
+}
+
+// recoverHalt writes the response of Halt, other panics are passed on with their stack
+func (c *Console) recoverHalt() {
+	exception := recover()
+	if exception == nil {
+		return
+	}
+	switch x := exception.(type) {
+	case haltPanic:
+		if x.ContentType != "" {
+			c.w.Header().Set("Content-Type", x.ContentType)
+		}
+		c.w.WriteHeader(x.Status)
+		c.w.Write(x.Body)
+	case apiPanic:
+		panic(x)
+	default:
+		panic(apiPanic{exception, debug.Stack()})
+	}
+}
+
+func consoleMethods() map[string]reflect.Method {
 	consoleMethodLock.Lock()
+	defer consoleMethodLock.Unlock()
 	if consoleMethodMap == nil {
 		consoleMethodMap = make(map[string]reflect.Method)
-		t := reflect.TypeOf(Console{})
-		for i := 0; i < t.NumMethod(); i++ {
-			method := t.Method(i)
+		for i := 0; i < consoleType.NumMethod(); i++ {
+			method := consoleType.Method(i)
 			consoleMethodMap[method.Name] = method
 		}
 	}
-	consoleMethodLock.Unlock()
-	cm, ok := consoleMethodMap[m.Name]
+	return consoleMethodMap
+}
+
+// isConsoleMethod tells whether m has the name and signature of a Console method, a service method
+// with a Console name but another signature stays an api
+func isConsoleMethod(m reflect.Method) bool {
+	cm, ok := consoleMethods()[m.Name]
 	if !ok {
 		return false
 	}
@@ -89,27 +156,108 @@ func isConsoleMethod(m reflect.Method) bool {
 	if t.NumIn() != ct.NumIn() || t.NumOut() != ct.NumOut() {
 		return false
 	}
-	if t.NumIn() > 1 {
-		for i := 1; i < t.NumIn(); i++ {
-			if t.In(i) != ct.In(i) {
-				return false
-			}
+	for i := 1; i < t.NumIn(); i++ {
+		if t.In(i) != ct.In(i) {
+			return false
 		}
 	}
-	if t.NumOut() > 0 {
-		for i := 0; i < t.NumOut(); i++ {
-			if t.Out(i) != ct.Out(i) {
-				return false
-			}
+	for i := 0; i < t.NumOut(); i++ {
+		if t.Out(i) != ct.Out(i) {
+			return false
 		}
 	}
 	return true
 }
 
-func (c *Console) SaveContext(a interface{}) {
-	c.context = a
+// StreamLastEventID returns the Last-Event-ID header sent by a reconnecting event stream client
+func (c Console) StreamLastEventID() string {
+	return c.r.Header.Get("Last-Event-ID")
+}
+
+// negotiate picks the response codec by Accept, falls back to the default codec when nothing matches
+func (c *Console) negotiate() (acceptable bool) {
+	if c.quark == nil {
+		return true
+	}
+	c.mediaType, c.codec, acceptable = c.quark.encoder(c.r.Header.Get("Accept"))
+	if !acceptable {
+		c.mediaType, c.codec, _ = c.quark.encoder("")
+	}
+	return
+}
+
+// valueStore keeps the per-request values of Console, values is made by the first SetValue
+type valueStore struct {
+	lock    sync.RWMutex
+	values  map[interface{}]interface{}
+	context interface{}
+}
+
+func newValueStore() *valueStore {
+	return new(valueStore)
+}
+
+func (c Console) mustStore() *valueStore {
+	if c.store == nil {
+		panic(errors.New("Console is not created by Quark or NewConsole"))
+	}
+	return c.store
+}
+
+// SaveContext keeps a single request value, it's visible to every copy of the Console, SetValue is preferred for more values
+func (c Console) SaveContext(a interface{}) {
+	store := c.mustStore()
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.context = a
 }
 
 func (c Console) Context() interface{} {
-	return c.context
+	if c.store == nil {
+		return nil
+	}
+	c.store.lock.RLock()
+	defer c.store.lock.RUnlock()
+	return c.store.context
+}
+
+// SetValue keeps v by key for the rest of the request, e.g. the principal resolved by Authenticate,
+// use an unexported key type to avoid collisions like context.WithValue
+func (c Console) SetValue(key, v interface{}) {
+	store := c.mustStore()
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.values == nil {
+		store.values = make(map[interface{}]interface{})
+	}
+	store.values[key] = v
+}
+
+// GetValue returns the value of key, nil if it's not set
+func (c Console) GetValue(key interface{}) interface{} {
+	if c.store == nil {
+		return nil
+	}
+	c.store.lock.RLock()
+	defer c.store.lock.RUnlock()
+	return c.store.values[key]
+}
+
+// LoadValue sets the value of key into the variable ptr points to, e.g. var u *User; c.LoadValue(userKey, &u),
+// it returns false if key is not set or its value is not assignable to the variable
+func (c Console) LoadValue(key, ptr interface{}) bool {
+	pv := reflect.ValueOf(ptr)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() {
+		panic(fmt.Errorf("LoadValue needs a non-nil pointer, but receive %T", ptr))
+	}
+	v := c.GetValue(key)
+	if v == nil {
+		return false
+	}
+	vv := reflect.ValueOf(v)
+	if !vv.Type().AssignableTo(pv.Elem().Type()) {
+		return false
+	}
+	pv.Elem().Set(vv)
+	return true
 }
@@ -3,7 +3,10 @@ package quark
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -21,6 +24,47 @@ func TestIsConsoleMethod(t *testing.T) {
 	}
 }
 
+// statusService declares methods named like Console methods, they are apis since their signatures differ
+type statusService struct {
+	Console
+}
+
+func (s statusService) Status() string { return "ok" }
+
+func (s statusService) Api() int { return 1 }
+
+type plainStatusService struct{}
+
+func (s plainStatusService) Status() int { return 1 }
+
+type badHookService struct {
+	Console
+}
+
+func (s badHookService) Routes() []string { return nil }
+
+func TestReservedMethods(t *testing.T) {
+	e := NewQuark().Register(badHookService{})
+	if e == nil || !strings.Contains(e.Error(), "method Routes doesn't match") {
+		t.Errorf("expects Routes not matching RouteDefiner reported but actual %v", e)
+	}
+	q := NewQuark()
+	if e := q.Register(statusService{}, plainStatusService{}); e != nil {
+		t.Fatal(e)
+	}
+	for path, rsp := range map[string]string{
+		"/statusService/status":      `"ok"`,
+		"/statusService/api":         "1",
+		"/plainStatusService/status": "1",
+	} {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != rsp {
+			t.Errorf("%s expects 200 %s but actual %d %s", path, rsp, w.Code, w.Body.String())
+		}
+	}
+}
+
 func TestMarshalError(t *testing.T) {
 	e := fmt.Errorf("TestError %d %s", 123, "hello")
 	j, err := json.Marshal(e)
@@ -29,3 +73,53 @@ func TestMarshalError(t *testing.T) {
 	}
 	t.Log(string(j))
 }
+
+type principalKey struct{}
+
+type principal struct {
+	Name string
+}
+
+type storeService struct {
+	Console
+}
+
+func (s storeService) Whoami() string {
+	var p *principal
+	if !s.LoadValue(principalKey{}, &p) {
+		return ""
+	}
+	s.SaveContext("seen by " + p.Name)
+	return p.Name + " " + s.GetValue("trace").(string)
+}
+
+func TestConsoleStore(t *testing.T) {
+	q := NewQuark()
+	q.WithAuthenticate(func(c *Console) bool {
+		c.SetValue(principalKey{}, &principal{c.Request().Header.Get("X-User")})
+		return true
+	})
+	var saved interface{}
+	q.Use(func(next Handler) Handler {
+		return func(c *Console) {
+			c.SetValue("trace", "t1")
+			next(c)
+			saved = c.Context()
+		}
+	})
+	q.RegisterService(storeService{})
+	r := httptest.NewRequest(http.MethodGet, "/storeService/whoami", nil)
+	r.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if w.Body.String() != `"alice t1"` {
+		t.Errorf("expects alice t1 but actual %s", w.Body.String())
+	}
+	if saved != "seen by alice" {
+		t.Errorf("expects the saved context visible to middlewares but actual %v", saved)
+	}
+	var n int
+	if c := NewConsole(w, r, nil); c.LoadValue("missing", &n) || c.GetValue("missing") != nil {
+		t.Error("expects missing keys not loaded")
+	}
+}
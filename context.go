@@ -0,0 +1,97 @@
+package quark
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// longLived tells whether the api is a stream, event stream or websocket
+func (a *Api) longLived() bool {
+	return a.stream != streamNone || a.websocket || a.hasArg(argEventStream)
+}
+
+// timeout returns the deadline of the api, Api.Timeout overrides Option.Timeout and a negative one disables it,
+// long-lived apis are never timed out
+func (a *Api) timeout() time.Duration {
+	if a.longLived() || a.Timeout < 0 {
+		return 0
+	}
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+	return a.Service().Quark().option.Timeout
+}
+
+// withTimeout derives the request context with the api deadline, it's cancelled when the client goes away as well
+func (a *Api) withTimeout(r *http.Request) (*http.Request, context.CancelFunc) {
+	d := a.timeout()
+	if d <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	return r.WithContext(ctx), cancel
+}
+
+// call runs the method, when the api has a deadline it runs aside and the request is answered by 504
+// once the deadline passes, or dropped if the client is gone, later writes of the method are discarded and
+// finishLate cleans up after it
+func (a *Api) call(c *Console, in []reflect.Value) []reflect.Value {
+	if a.timeout() <= 0 {
+		return a.ReflectMethod.Func.Call(in)
+	}
+	ctx := c.r.Context()
+	var (
+		out       []reflect.Value
+		exception interface{}
+		done      = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		defer func() {
+			if x := recover(); x != nil {
+				switch x.(type) {
+				case haltPanic, apiPanic:
+					exception = x
+				default:
+					exception = apiPanic{x, debug.Stack()}
+				}
+			}
+		}()
+		out = a.ReflectMethod.Func.Call(in)
+	}()
+	select {
+	case <-done:
+		if exception != nil {
+			panic(exception)
+		}
+		return out
+	case <-ctx.Done():
+		var p *Problem
+		if ctx.Err() == context.DeadlineExceeded {
+			p = NewProblem(http.StatusGatewayTimeout, "no response within "+a.timeout().String())
+			p.Instance = c.r.URL.Path
+		}
+		c.w.timeout(p)
+		go a.finishLate(c, done, &exception)
+		return nil
+	}
+}
+
+// finishLate waits for a method which outlived its request, Run leaves the multipart form to it since the method
+// may still read the files, and a panic is logged as nobody else sees it
+func (a *Api) finishLate(c *Console, done <-chan struct{}, exception *interface{}) {
+	<-done
+	if x, ok := (*exception).(apiPanic); ok {
+		c.quark.panicProblem(x.Value, x.Stack)
+	}
+	if form := c.r.MultipartForm; form != nil {
+		form.RemoveAll()
+	}
+}
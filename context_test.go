@@ -0,0 +1,177 @@
+package quark
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type contextService struct {
+	Console
+}
+
+func (s contextService) ConfigureApi(api *Api) {
+	switch api.Name() {
+	case "Fast":
+		api.Timeout = 5 * time.Millisecond
+	case "Unlimited":
+		api.Timeout = -1
+	}
+}
+
+func (s contextService) Wait(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s contextService) Ignore() string {
+	time.Sleep(50 * time.Millisecond)
+	s.ResponseWriter().Header().Set("X-Late", "1")
+	return "late"
+}
+
+func (s contextService) Fast(ctx context.Context) string {
+	deadline, _ := ctx.Deadline()
+	if time.Until(deadline) > 5*time.Millisecond {
+		return "slow"
+	}
+	return "fast"
+}
+
+func (s contextService) Unlimited(ctx context.Context) bool {
+	_, ok := ctx.Deadline()
+	return ok
+}
+
+func (s contextService) Downstream() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// lateService has methods which outlive their deadline, they report what they see afterwards to seen
+type lateService struct {
+	Console
+	seen chan string
+}
+
+func (s lateService) ConfigureApi(api *Api) {
+	api.Timeout = 5 * time.Millisecond
+}
+
+func (s lateService) POST_Upload(req struct {
+	Doc File `quark:"doc"`
+}) {
+	time.Sleep(30 * time.Millisecond)
+	f, e := req.Doc.Open()
+	if e != nil {
+		s.seen <- e.Error()
+		return
+	}
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	s.seen <- string(b)
+}
+
+func (s lateService) Crash() {
+	time.Sleep(30 * time.Millisecond)
+	panic("late crash")
+}
+
+// logLines receives each line written to the log
+type logLines chan string
+
+func (l logLines) Write(b []byte) (int, error) {
+	l <- string(b)
+	return len(b), nil
+}
+
+func TestContextTimeoutLate(t *testing.T) {
+	seen := make(chan string, 1)
+	q := NewQuark()
+	q.WithMultipart(1, 0) // spools the files to temp files
+	q.RegisterService(lateService{seen: seen})
+
+	b, ct := multipartBody(t, map[string][]string{"doc": {"content"}}, nil)
+	r := httptest.NewRequest(http.MethodPost, "/lateService/upload", b)
+	r.Header.Set("Content-Type", ct)
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("upload expects 504 but actual %d", w.Code)
+	}
+	select {
+	case s := <-seen:
+		if s != "content" {
+			t.Errorf("expects the file readable after the deadline but actual %s", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upload expects to finish")
+	}
+
+	lines := make(logLines, 8)
+	log.SetOutput(lines)
+	defer log.SetOutput(os.Stderr)
+	w = httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/lateService/crash", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("crash expects 504 but actual %d", w.Code)
+	}
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "panic late crash") {
+			t.Errorf("expects the late panic logged but actual %s", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expects the late panic logged")
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(contextService{})
+	q.WithTimeout(20 * time.Millisecond)
+	Expect := func(path string, status int, rsp string) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("%s expects status %d but actual %d", path, status, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); status == http.StatusGatewayTimeout && ct != MediaTypeProblemJson {
+			t.Errorf("%s expects the timeout as %s but actual %s", path, MediaTypeProblemJson, ct)
+		}
+		if rsp != "" && w.Body.String() != rsp {
+			t.Errorf("%s expects %q but actual %q", path, rsp, w.Body.String())
+		}
+	}
+	Expect("/contextService/wait", http.StatusGatewayTimeout, "")
+	Expect("/contextService/ignore", http.StatusGatewayTimeout,
+		`{"detail":"no response within 20ms","instance":"/contextService/ignore","status":504,"title":"Gateway Timeout","type":"about:blank"}`)
+	Expect("/contextService/fast", http.StatusOK, `"fast"`)
+	Expect("/contextService/unlimited", http.StatusOK, "false")
+	Expect("/contextService/downstream", http.StatusGatewayTimeout, "")
+	time.Sleep(50 * time.Millisecond) // let ignore finish its late writes
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/contextService/wait", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		q.ServeHTTP(w, r)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait expects to return when the client goes away")
+	}
+}
@@ -0,0 +1,136 @@
+package quark
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS lets browsers call apis from other origins, preflight requests are answered before authentication
+type CORS struct {
+	AllowOrigins     []string      // "*", origins, or patterns with wildcards like https://*.example.com and http://localhost:*
+	AllowMethods     []string      // methods allowed by preflights, blank allows the method of every api
+	AllowHeaders     []string      // request headers allowed by preflights, "*" allows the requested ones
+	ExposeHeaders    []string      // response headers readable by scripts
+	AllowCredentials bool          // allows cookies and authorization, the origin is echoed instead of *
+	MaxAge           time.Duration // how long preflight results are cached by browsers, 0 leaves it to them
+}
+
+// CORSDefiner is implemented by a service struct which overrides Option.CORS for its apis, nil keeps Option.CORS
+// and a CORS without origins disables it
+type CORSDefiner interface {
+	CORS() *CORS
+}
+
+func (c *CORS) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range c.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if matched, _ := path.Match(strings.ToLower(o), origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowMethod(method string) bool {
+	if len(c.AllowMethods) == 0 {
+		return true
+	}
+	for _, m := range c.AllowMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// echoOrigin tells whether allowed origins are echoed, so responses vary by Origin
+func (c *CORS) echoOrigin() bool {
+	return c.AllowCredentials || !c.anyOrigin()
+}
+
+// writeOrigin writes the headers of requests from origin, which must be allowed
+func (c *CORS) writeOrigin(h http.Header, origin string) {
+	if c.echoOrigin() {
+		h.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) anyOrigin() bool {
+	for _, o := range c.AllowOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight answers a preflight request for method, a disallowed origin or method is answered by 403
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, method string) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	origin := r.Header.Get("Origin")
+	if !c.allowOrigin(origin) {
+		writeProblem(w, r, NewProblem(http.StatusForbidden, "origin "+origin+" is not allowed"))
+		return
+	}
+	if !c.allowMethod(method) {
+		writeProblem(w, r, NewProblem(http.StatusForbidden, "method "+method+" is not allowed for cross-origin requests"))
+		return
+	}
+	c.writeOrigin(h, origin)
+	if len(c.AllowMethods) == 0 {
+		h.Set("Access-Control-Allow-Methods", method)
+	} else {
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowMethods, ", "))
+	}
+	if len(c.AllowHeaders) == 1 && c.AllowHeaders[0] == "*" {
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else if len(c.AllowHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actual writes the headers of a request from an allowed origin other than preflights
+func (c *CORS) actual(h http.Header, origin string) {
+	if c.echoOrigin() {
+		h.Add("Vary", "Origin")
+	}
+	if !c.allowOrigin(origin) {
+		return
+	}
+	c.writeOrigin(h, origin)
+	if len(c.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
+	}
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// cors returns the CORS of the api, Option.CORS if its service doesn't override it or api is nil
+func (q *Quark) cors(api *Api) *CORS {
+	if api != nil && api.Service().cors != nil {
+		return api.Service().cors
+	}
+	return q.option.CORS
+}
@@ -0,0 +1,95 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type corsService struct {
+	Console
+}
+
+func (s corsService) POST_Order(req struct{ Item string }) string {
+	return req.Item
+}
+
+type partnerService struct {
+	Console
+}
+
+func (s partnerService) CORS() *CORS {
+	return &CORS{AllowOrigins: []string{"https://partner.com"}, AllowCredentials: true}
+}
+
+func (s partnerService) Quote() string {
+	return "quote"
+}
+
+func TestCORS(t *testing.T) {
+	q := NewQuark()
+	q.WithAuthenticate(func(c *Console) bool {
+		return c.Request().Header.Get("Authorization") != ""
+	})
+	q.WithCORS(CORS{
+		AllowOrigins:  []string{"https://app.example.com", "https://*.example.org"},
+		AllowHeaders:  []string{"Authorization", "Content-Type"},
+		ExposeHeaders: []string{"X-Request-Id"},
+		MaxAge:        10 * time.Minute,
+	})
+	q.RegisterService(corsService{}, partnerService{})
+	Do := func(method, path, origin string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Origin", origin)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		return w
+	}
+	preflight := map[string]string{"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "authorization"}
+
+	w := Do("OPTIONS", "/corsService/order", "https://shop.example.org", preflight)
+	if h := w.Header(); w.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://shop.example.org" ||
+		h.Get("Access-Control-Allow-Methods") != "POST" || h.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" ||
+		h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected preflight %d %v", w.Code, h)
+	}
+	if w := Do("OPTIONS", "/corsService/order", "https://evil.com", preflight); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expects preflight of other origins forbidden but actual %d %v", w.Code, w.Header())
+	}
+	w = Do("POST", "/corsService/order", "https://app.example.com", nil)
+	if h := w.Header(); w.Code != http.StatusUnauthorized || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Expose-Headers") != "X-Request-Id" || h.Get("Vary") != "Origin" {
+		t.Errorf("expects CORS headers on failed auth but actual %d %v", w.Code, h)
+	}
+	w = Do("POST", "/corsService/order", "https://app.example.com", map[string]string{"Authorization": "t", "Content-Type": MediaTypeJson})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	preflight["Access-Control-Request-Method"] = "GET"
+	if w := Do("OPTIONS", "/partnerService/quote", "https://app.example.com", preflight); w.Code != http.StatusForbidden {
+		t.Errorf("expects the service CORS to override the global one but actual %d", w.Code)
+	}
+	w = Do("OPTIONS", "/partnerService/quote", "https://partner.com", preflight)
+	if h := w.Header(); w.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://partner.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("unexpected preflight of the service CORS %d %v", w.Code, h)
+	}
+}
+
+func TestPreflightWithoutServices(t *testing.T) {
+	q := NewQuark()
+	q.WithCORS(CORS{AllowOrigins: []string{"*"}})
+	r := httptest.NewRequest(http.MethodOptions, "/x", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expects 404 before any service is registered but actual %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,198 @@
+package quark
+
+import (
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// docsFS holds the page and the Swagger UI files it loads, only the css, the bundle and the favicons are embedded
+// to keep binaries small, see docs/LICENSE-swagger-ui
+//
+//go:embed docs/index.html docs/swagger-ui.css docs/swagger-ui-bundle.js docs/favicon-16x16.png docs/favicon-32x32.png
+var docsFS embed.FS
+
+var (
+	// docsAssets are the Swagger UI files loaded by the docs page, by name and content type
+	docsAssets = map[string]string{
+		"/swagger-ui.css":       "text/css; charset=utf-8",
+		"/swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+		"/favicon-16x16.png":    "image/png",
+		"/favicon-32x32.png":    "image/png",
+	}
+	docsAssetCache sync.Map // name -> *docsAsset
+)
+
+type docsAsset struct {
+	body []byte
+	etag string
+}
+
+// Docs serves the document of the apis and a Swagger UI page viewing it, under Option.PathPrefix:
+// Path/ is the page, Path/swagger.json the Swagger 2.0 document and Path/swagger.yaml, or Path/openapi.yaml,
+// the same document in YAML.
+// Swagger UI is embedded in Quark, the page works offline
+type Docs struct {
+	Path         string           // e.g. /docs
+	Authenticate AuthenticateFunc // nil serves the docs to everyone, Option.Authenticate isn't applied to them
+	MaxAge       time.Duration    // how long clients cache the docs, 0 makes them revalidate by ETag every time
+}
+
+// WithDocs serves the docs at path, the returned Docs may be tuned before serving
+func (q *Quark) WithDocs(path string) *Docs {
+	d := &Docs{Path: "/" + cleanMount(path)}
+	q.option.Docs = d
+	return d
+}
+
+// docsCache keeps the encoded documents until services or codecs change
+type docsCache struct {
+	json, yaml []byte
+	etag       string
+}
+
+func (q *Quark) docs() (*docsCache, error) {
+	doc := q.SwaggerSpec()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.docsCache != nil {
+		return q.docsCache, nil
+	}
+	b, e := json.Marshal(doc)
+	if e != nil {
+		return nil, e
+	}
+	y, e := jsonToYaml(b)
+	if e != nil {
+		return nil, e
+	}
+	sum := sha1.Sum(b)
+	q.docsCache = &docsCache{json: b, yaml: y, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+	return q.docsCache, nil
+}
+
+// jsonToYaml converts the json document into block style yaml keeping the order of keys
+func jsonToYaml(b []byte) ([]byte, error) {
+	var n yaml.Node
+	if e := yaml.Unmarshal(b, &n); e != nil {
+		return nil, e
+	}
+	var block func(n *yaml.Node)
+	block = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			block(c)
+		}
+	}
+	block(&n)
+	return yaml.Marshal(&n)
+}
+
+// readDocsAsset returns the embedded Swagger UI file of name
+func readDocsAsset(name string) (*docsAsset, error) {
+	if a, ok := docsAssetCache.Load(name); ok {
+		return a.(*docsAsset), nil
+	}
+	b, e := docsFS.ReadFile("docs" + name)
+	if e != nil {
+		return nil, e
+	}
+	sum := sha1.Sum(b)
+	a := &docsAsset{b, `"` + hex.EncodeToString(sum[:8]) + `"`}
+	docsAssetCache.Store(name, a)
+	return a, nil
+}
+
+// serveDocs serves the docs if path is one of them, path has no Option.PathPrefix
+func (q *Quark) serveDocs(w http.ResponseWriter, r *http.Request, path string, fold bool) bool {
+	d := q.option.Docs
+	if d == nil || !hasPrefix(path, d.Path, fold) {
+		return false
+	}
+	var (
+		name        = path[len(d.Path):]
+		contentType string
+	)
+	switch {
+	case name == "":
+		redirectTrailingSlash(w, r, r.URL.Path+"/")
+		return true
+	case name == "/", strings.EqualFold(name, "/index.html"):
+		contentType = "text/html; charset=utf-8"
+	case strings.EqualFold(name, "/swagger.json"):
+		contentType = MediaTypeJson
+	case strings.EqualFold(name, "/swagger.yaml"), strings.EqualFold(name, "/openapi.yaml"):
+		contentType = "application/yaml"
+	default:
+		name = strings.ToLower(name)
+		if contentType = docsAssets[name]; contentType == "" {
+			return false
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, NewProblem(http.StatusMethodNotAllowed, "docs are read by GET"))
+		return true
+	}
+	if d.Authenticate != nil && !d.Authenticate(NewConsole(w, r, nil)) {
+		writeProblem(w, r, NewProblem(http.StatusUnauthorized, ""))
+		return true
+	}
+	var (
+		body []byte
+		etag string
+	)
+	if _, ok := docsAssets[name]; ok {
+		a, e := readDocsAsset(name)
+		if e != nil {
+			writeProblem(w, r, NewProblem(http.StatusInternalServerError, "read docs asset fail, "+e.Error()))
+			return true
+		}
+		body, etag = a.body, a.etag
+	} else {
+		cache, e := q.docs()
+		if e != nil {
+			writeProblem(w, r, NewProblem(http.StatusInternalServerError, "encode docs fail, "+e.Error()))
+			return true
+		}
+		switch contentType {
+		case MediaTypeJson:
+			body = cache.json
+		case "application/yaml":
+			body = cache.yaml
+		default:
+			body, _ = docsFS.ReadFile("docs/index.html")
+		}
+		etag = cache.etag
+	}
+	h := w.Header()
+	visibility := "public"
+	if d.Authenticate != nil {
+		visibility = "private"
+	}
+	if d.MaxAge > 0 {
+		h.Set("Cache-Control", visibility+", max-age="+strconv.Itoa(int(d.MaxAge/time.Second)))
+	} else {
+		h.Set("Cache-Control", visibility+", no-cache")
+	}
+	h.Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == "*" || strings.Contains(match, etag)) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+	return true
+}
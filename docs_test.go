@@ -0,0 +1,110 @@
+package quark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type docsService struct {
+	Console
+}
+
+func (s docsService) Greet_name(name string) string {
+	return "hello " + name
+}
+
+func TestDocs(t *testing.T) {
+	q := NewQuark()
+	q.WithPathPrefix([]string{"api"})
+	docs := q.WithDocs("docs")
+	docs.MaxAge = time.Hour
+	q.RegisterService(docsService{})
+	Do := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		return w
+	}
+	if w := Do("GET", "/api/docs", nil); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/api/docs/" {
+		t.Errorf("expects redirect to /api/docs/ but actual %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := Do("GET", "/api/docs/", nil); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(w.Body.String(), `url: "swagger.json"`) {
+		t.Errorf("unexpected docs page %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := Do("GET", "/docs/swagger.json", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expects docs under the path prefix but actual %d", w.Code)
+	}
+
+	w := Do("GET", "/api/docs/swagger.json", nil)
+	var doc struct {
+		BasePath string                 `json:"basePath"`
+		Paths    map[string]interface{} `json:"paths"`
+	}
+	if e := json.Unmarshal(w.Body.Bytes(), &doc); e != nil || doc.BasePath != "/api" || doc.Paths["/docsService/greet/{name}"] == nil {
+		t.Errorf("unexpected swagger.json %v %s", e, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("unexpected caching headers %v", w.Header())
+	}
+	if w := Do("GET", "/api/docs/swagger.json", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+		t.Errorf("expects 304 for the same ETag but actual %d", w.Code)
+	}
+
+	w = Do("GET", "/api/docs/swagger.yaml", nil)
+	var y map[string]interface{}
+	if e := yaml.Unmarshal(w.Body.Bytes(), &y); e != nil || y["swagger"] != "2.0" || y["basePath"] != "/api" ||
+		w.Header().Get("ETag") != etag || strings.HasPrefix(w.Body.String(), "{") {
+		t.Errorf("unexpected swagger.yaml %v %s", e, w.Body.String())
+	}
+	if w := Do("GET", "/api/docs/openapi.yaml", nil); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/yaml" ||
+		w.Header().Get("ETag") != etag {
+		t.Errorf("expects openapi.yaml as swagger.yaml but actual %d %v", w.Code, w.Header())
+	}
+	for _, asset := range []string{"swagger-ui.css", "favicon-16x16.png", "favicon-32x32.png"} {
+		if w := Do("GET", "/api/docs/"+asset, nil); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("expects %s embedded but actual %d", asset, w.Code)
+		}
+	}
+	if w := Do("GET", "/api/docs/swagger-ui.js", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expects only the bundle of Swagger UI embedded but actual %d", w.Code)
+	}
+	w = Do("GET", "/api/docs/swagger-ui-bundle.js", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") ||
+		!strings.Contains(w.Body.String(), "SwaggerUIBundle") || w.Header().Get("ETag") == "" {
+		t.Errorf("expects Swagger UI embedded but actual %d %v", w.Code, w.Header())
+	}
+	if w := Do("GET", "/api/docs/swagger-ui.css", map[string]string{"If-None-Match": w.Header().Get("ETag")}); w.Code != http.StatusOK {
+		t.Errorf("expects the css by its own ETag but actual %d", w.Code)
+	}
+	if w := Do("HEAD", "/api/docs/swagger.json", nil); w.Code != http.StatusOK || w.Body.Len() > 0 || w.Header().Get("Content-Length") == "" {
+		t.Errorf("unexpected HEAD %d %d %v", w.Code, w.Body.Len(), w.Header())
+	}
+	if w := Do("POST", "/api/docs/swagger.json", nil); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("expects 405 but actual %d", w.Code)
+	}
+	if w := Do("GET", "/api/docsService/greet/bob", nil); w.Code != http.StatusOK || w.Body.String() != `"hello bob"` {
+		t.Errorf("expects apis served along with the docs but actual %d %s", w.Code, w.Body.String())
+	}
+
+	docs.Authenticate = func(c *Console) bool {
+		return c.Request().Header.Get("Authorization") == "Bearer docs"
+	}
+	if w := Do("GET", "/api/docs/swagger.json", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expects 401 but actual %d", w.Code)
+	}
+	if w := Do("GET", "/api/docs/swagger.json", map[string]string{"Authorization": "Bearer docs"}); w.Code != http.StatusOK ||
+		w.Header().Get("Cache-Control") != "private, max-age=3600" {
+		t.Errorf("expects private docs but actual %d %v", w.Code, w.Header())
+	}
+}
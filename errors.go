@@ -0,0 +1,94 @@
+package quark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// HTTPError is implemented by errors which decide their own response status
+type HTTPError interface {
+	error
+	StatusCode() int
+}
+
+// ErrorEncoder writes a non-nil error returned by an api method into the response
+type ErrorEncoder func(c *Console, e error)
+
+// Error is a plain HTTPError
+type Error struct {
+	Status  int
+	Message string
+}
+
+func NewError(status int, format string, args ...interface{}) *Error {
+	return &Error{
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) StatusCode() int {
+	return e.Status
+}
+
+// ErrorStatus returns the status of HTTPError, also wrapped in e, 504 for deadline exceeded errors,
+// 503 for canceled contexts, or 500 for other errors
+func ErrorStatus(e error) int {
+	var he HTTPError
+	if errors.As(e, &he) {
+		if status := he.StatusCode(); status > 0 {
+			return status
+		}
+	}
+	if errors.Is(e, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(e, context.Canceled) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// DefaultErrorEncoder writes the error as application/problem+json with ErrorStatus(e),
+// in production the message of errors which are not HTTPError is logged instead of written
+func DefaultErrorEncoder(c *Console, e error) {
+	p := ProblemOf(e)
+	var he HTTPError
+	if !errors.As(e, &he) && c.quark != nil && c.quark.option.Production {
+		log.Printf("quark: %s %v", c.Request().URL.Path, e)
+		p = NewProblem(p.Status, "")
+	}
+	writeProblem(c.ResponseWriter(), c.Request(), p)
+}
+
+func (q *Quark) encodeError(c *Console, e error) {
+	if f := q.option.ErrorEncoder; f != nil {
+		f(c, e)
+		return
+	}
+	DefaultErrorEncoder(c, e)
+}
+
+// RegisterError lists every problem found by Quark.Register
+type RegisterError []error
+
+func (e RegisterError) Error() string {
+	ss := make([]string, len(e))
+	for i := range e {
+		ss[i] = e[i].Error()
+	}
+	return "register fail, " + strings.Join(ss, "; ")
+}
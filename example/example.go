@@ -125,13 +125,11 @@ func Authenticate(c *quark.Console) bool {
 }
 
 func main() {
+	q.WithAmbiguousRoutes(true) // Vehicle_Vin takes precedence over Vehicle_vins on /vehicle/vin
 	q.RegisterService(example{})
 	//q.WithAuthenticate(Authenticate)
 	q.WithPathPrefix([]string{"open", "v1"})
-	fmt.Println(quark.Js(q.SwaggerSpec()))
-	for i := range q.Services {
-		log.Println(q.Services[i])
-		q.Services[i].DumpPaths()
-	}
+	q.WithDocs("/docs") // http://localhost:11019/open/v1/docs/
+	fmt.Print(q.Routes())
 	http.ListenAndServe(":11019", q)
 }
@@ -0,0 +1,142 @@
+package quark
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	MediaTypeMultipart = "multipart/form-data"
+
+	defaultMultipartMemory = 32 << 20
+)
+
+// File is an uploaded part of multipart/form-data, bound by name like form fields,
+// e.g. `Avatar quark.File` or `Photos []quark.File` in request struct
+type File struct {
+	Filename    string
+	ContentType string
+	Size        int64
+	Header      *multipart.FileHeader
+}
+
+// Open returns a reader of the file content, which is in memory or spooled to a temp file
+func (f File) Open() (multipart.File, error) {
+	if f.Header == nil {
+		return nil, errors.New("quark.File is empty")
+	}
+	return f.Header.Open()
+}
+
+var (
+	FileType        = reflect.TypeOf(File{})
+	FilePointerType = reflect.PointerTo(FileType)
+	FileSliceType   = reflect.SliceOf(FileType)
+
+	ErrBodyTooLarge = NewError(http.StatusRequestEntityTooLarge, "request body too large")
+)
+
+func IsFileType(t reflect.Type) bool {
+	return t == FileType || t == FilePointerType || t == FileSliceType
+}
+
+func newFile(fh *multipart.FileHeader) File {
+	return File{
+		Filename:    fh.Filename,
+		ContentType: fh.Header.Get("Content-Type"),
+		Size:        fh.Size,
+		Header:      fh,
+	}
+}
+
+// SetFileValue sets uploaded files into v whose type must be a file type
+func SetFileValue(v reflect.Value, fhs []*multipart.FileHeader) {
+	if len(fhs) == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	switch v.Type() {
+	case FileType:
+		v.Set(reflect.ValueOf(newFile(fhs[0])))
+	case FilePointerType:
+		f := newFile(fhs[0])
+		v.Set(reflect.ValueOf(&f))
+	case FileSliceType:
+		files := make([]File, len(fhs))
+		for i := range fhs {
+			files[i] = newFile(fhs[i])
+		}
+		v.Set(reflect.ValueOf(files))
+	}
+}
+
+func isMultipart(r *http.Request) bool {
+	return hasMediaType(r, MediaTypeMultipart)
+}
+
+// hasMediaType tells whether the Content-Type of r is mediaType, the prefix is compared before parsing
+func hasMediaType(r *http.Request, mediaType string) bool {
+	ct := r.Header.Get("Content-Type")
+	if len(ct) < len(mediaType) || !strings.EqualFold(ct[:len(mediaType)], mediaType) {
+		return false
+	}
+	mt, _, e := mime.ParseMediaType(ct)
+	return e == nil && mt == mediaType
+}
+
+// limitedBody fails with ErrBodyTooLarge once more than n bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	n        int64
+	exceeded bool
+}
+
+func limitBody(body io.ReadCloser, n int64) *limitedBody {
+	return &limitedBody{ReadCloser: body, n: n}
+}
+
+func (l *limitedBody) Read(b []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(b)) > l.n+1 {
+		b = b[:l.n+1]
+	}
+	n, e := l.ReadCloser.Read(b)
+	if int64(n) > l.n {
+		l.exceeded = true
+		return int(l.n), ErrBodyTooLarge
+	}
+	l.n -= int64(n)
+	return n, e
+}
+
+// parseMultipart keeps up to MultipartMemory bytes of the parts in memory and spools the rest to temp files,
+// the body is limited by MaxUploadSize, or bodyLimit when it's 0
+func (q *Quark) parseMultipart(r *http.Request, bodyLimit int64) (status int, e error) {
+	memory := q.option.MultipartMemory
+	if memory <= 0 {
+		memory = defaultMultipartMemory
+	}
+	var limited *limitedBody
+	max := q.option.MaxUploadSize
+	if max <= 0 {
+		max = bodyLimit
+	}
+	if max > 0 {
+		limited = limitBody(r.Body, max)
+		r.Body = limited
+	}
+	if e = r.ParseMultipartForm(memory); e != nil {
+		if limited != nil && limited.exceeded {
+			return http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+		}
+		return http.StatusBadRequest, e
+	}
+	return 0, nil
+}
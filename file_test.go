@@ -0,0 +1,83 @@
+package quark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type uploadService struct {
+	Console
+}
+
+func (s uploadService) Upload(req struct {
+	Title  String `quark:"title,in=form"`
+	Avatar File   `quark:"avatar,required"`
+	Photos []File `quark:"photos"`
+}) (rsp []string) {
+	for _, f := range append([]File{req.Avatar}, req.Photos...) {
+		r, e := f.Open()
+		if e != nil {
+			s.Halt(http.StatusInternalServerError, e)
+		}
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		rsp = append(rsp, req.Title.V()+":"+f.Filename+":"+string(b))
+	}
+	return
+}
+
+func multipartBody(t *testing.T, files map[string][]string, fields map[string]string) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for name, contents := range files {
+		for i, content := range contents {
+			fw, e := mw.CreateFormFile(name, name+string(rune('0'+i))+".txt")
+			if e != nil {
+				t.Fatal(e)
+			}
+			fw.Write([]byte(content))
+		}
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestUpload(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(uploadService{})
+	Expect := func(files map[string][]string, status int, body string) {
+		b, ct := multipartBody(t, files, map[string]string{"title": "t"})
+		r := httptest.NewRequest(http.MethodPost, "/uploadService/upload", b)
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		if w.Code != status || (status == http.StatusOK && w.Body.String() != body) {
+			t.Errorf("%v expects %d %s but actual %d %s", files, status, body, w.Code, w.Body.String())
+		}
+	}
+	Expect(map[string][]string{"avatar": {"me"}, "photos": {"p0", "p1"}}, http.StatusOK,
+		`["t:avatar0.txt:me","t:photos0.txt:p0","t:photos1.txt:p1"]`)
+	Expect(map[string][]string{"photos": {"p0"}}, http.StatusBadRequest, "")
+
+	q.WithMultipart(16, 64)
+	Expect(map[string][]string{"avatar": {strings.Repeat("x", 128)}}, http.StatusRequestEntityTooLarge, "")
+
+	q.SwaggerSpec()
+	op := q.Services[0].Apis[0].SwaggerOperations()
+	if len(op.Consumes) != 1 || op.Consumes[0] != MediaTypeMultipart {
+		t.Errorf("upload expects consumes %s but actual %v", MediaTypeMultipart, op.Consumes)
+	}
+	for _, p := range op.Parameters {
+		if p.Name == "avatar" && (p.Type != "file" || p.In != "formData" || !p.Required) {
+			t.Errorf("unexpected avatar parameter %s", Js(p))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package quark
+
+import (
+	"strings"
+)
+
+// ServiceNamer is implemented by a service struct which is mounted at a path other than its type name,
+// e.g. "fleet/vehicles", a blank one mounts the service at the root
+type ServiceNamer interface {
+	ServiceName() string
+}
+
+// Group mounts services under a path prefix with middlewares shared by them, like router groups,
+// e.g. v1 := q.Group("v1", auth); v1.RegisterService(Vehicle{}) serves /v1/Vehicle/...
+type Group struct {
+	quark       *Quark
+	prefix      string
+	middlewares []Middleware
+	version     string
+	deprecation *Deprecation
+}
+
+// Group returns a group at prefix, its middlewares run inside the global ones and outside the service ones
+func (q *Quark) Group(prefix string, mws ...Middleware) *Group {
+	return &Group{
+		quark:       q,
+		prefix:      cleanMount(prefix),
+		middlewares: mws,
+	}
+}
+
+// Group returns a nested group, which inherits the prefix and middlewares of g
+func (g *Group) Group(prefix string, mws ...Middleware) *Group {
+	return &Group{
+		quark:       g.quark,
+		prefix:      joinMount(g.prefix, cleanMount(prefix)),
+		middlewares: append(append([]Middleware{}, g.middlewares...), mws...),
+		version:     g.version,
+		deprecation: g.deprecation,
+	}
+}
+
+// Use appends middlewares for the services registered into g afterwards
+func (g *Group) Use(mws ...Middleware) {
+	g.middlewares = append(g.middlewares, mws...)
+}
+
+// Register is Quark.Register under the group
+func (g *Group) Register(instances ...interface{}) error {
+	return g.quark.register(g, nil, instances)
+}
+
+// RegisterService is Register which panics on the error
+func (g *Group) RegisterService(instances ...interface{}) {
+	if e := g.Register(instances...); e != nil {
+		panic(e)
+	}
+}
+
+// Mount registers inst at path under the group instead of its own name, so a service type can be
+// mounted several times, a blank path mounts it at the group prefix
+func (g *Group) Mount(path string, inst interface{}) error {
+	return g.quark.register(g, &path, []interface{}{inst})
+}
+
+// Mount registers inst at path instead of its own name, a blank path mounts it at the root
+func (q *Quark) Mount(path string, inst interface{}) error {
+	return q.register(nil, &path, []interface{}{inst})
+}
+
+// cleanMount trims slashes and drops empty segments of a mount path
+func cleanMount(path string) string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s = strings.TrimSpace(s); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func joinMount(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case name == "", name == ROOT_SERVICE_NAME:
+		return prefix
+	}
+	return prefix + "/" + name
+}
@@ -0,0 +1,94 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fleetService struct {
+	Console
+}
+
+func (s fleetService) ServiceName() string {
+	return "/fleet/vehicles/"
+}
+
+func (s fleetService) Count() int {
+	return 3
+}
+
+type carService struct {
+	Console
+}
+
+func (s carService) Name() string {
+	return "car"
+}
+
+type homeService struct {
+	Console
+}
+
+func (s homeService) ServiceName() string {
+	return ""
+}
+
+func (s homeService) Hello() string {
+	return "home"
+}
+
+func tagMiddleware(tag string) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Console) {
+			c.ResponseWriter().Header().Add("X-Tags", tag)
+			next(c)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	q := NewQuark()
+	q.WithPathPrefix([]string{"api"})
+	q.RegisterService(fleetService{}, homeService{})
+	if e := q.Mount("v1/cars", carService{}); e != nil {
+		t.Fatal(e)
+	}
+	admin := q.Group("admin", tagMiddleware("admin"))
+	ops := admin.Group("/ops/", tagMiddleware("ops"))
+	ops.Use(tagMiddleware("late"))
+	ops.RegisterService(carService{})
+	if e := admin.Mount("cars", carService{}); e != nil {
+		t.Fatal(e)
+	}
+	if e := q.Mount("v1/cars", carService{}); e == nil || !strings.Contains(e.Error(), "v1/cars: registered twice") {
+		t.Errorf("expects the mount path registered twice but actual %v", e)
+	}
+
+	Expect := func(path string, rsp string, tags string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != rsp {
+			t.Errorf("%s expects %s but actual %d %s", path, rsp, w.Code, w.Body.String())
+		}
+		if actual := strings.Join(w.Header().Values("X-Tags"), ","); actual != tags {
+			t.Errorf("%s expects middlewares %s but actual %s", path, tags, actual)
+		}
+	}
+	Expect("/api/fleet/vehicles/count", "3", "")
+	Expect("/api/hello", `"home"`, "")
+	Expect("/api/v1/cars/name", `"car"`, "")
+	Expect("/api/admin/ops/carService/name", `"car"`, "admin,ops,late")
+	Expect("/api/admin/cars/name", `"car"`, "admin")
+
+	paths := q.SwaggerSpec().Paths.Paths
+	for _, path := range []string{"/fleet/vehicles/count", "/hello", "/v1/cars/name", "/admin/ops/carService/name", "/admin/cars/name"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("expects %s documented", path)
+		}
+	}
+	if q.SwaggerSpec().BasePath != "/api" {
+		t.Errorf("expects base path /api but actual %s", q.SwaggerSpec().BasePath)
+	}
+}
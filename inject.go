@@ -0,0 +1,93 @@
+package quark
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Provide registers providers of shared values, each one is a func() T, e.g. q.Provide(func() *sql.DB { return db }),
+// T is then injected into service fields tagged `quark:",inject"` and into method parameters of type T.
+// A provider is called on every injection, return the same value from it for singletons.
+// Providers must be registered before the services using them.
+func (q *Quark) Provide(providers ...interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.providers == nil {
+		q.providers = make(map[reflect.Type]reflect.Value)
+	}
+	for _, p := range providers {
+		v := reflect.ValueOf(p)
+		t := v.Type()
+		if t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() != 1 {
+			panic(fmt.Errorf("provider must be func() T, but receive %s", t))
+		}
+		q.providers[t.Out(0)] = v
+	}
+}
+
+// injectField is a service field filled by a provider for every request
+type injectField struct {
+	index    int
+	provider reflect.Value
+}
+
+func isInjectField(f reflect.StructField) bool {
+	for _, kv := range QuarkTagOptions(f) {
+		if kv[0] == "inject" {
+			return true
+		}
+	}
+	return false
+}
+
+// isServiceFactory tells whether inst is a func() S registered instead of a service instance S
+func isServiceFactory(t reflect.Type) bool {
+	return t.Kind() == reflect.Func && t.NumIn() == 0 && t.NumOut() == 1 && t.Out(0).Kind() == reflect.Struct
+}
+
+// bindInstance keeps the registered instance or factory and resolves the tagged fields of the service
+func (s *Service) bindInstance(inst interface{}) error {
+	v := reflect.ValueOf(inst)
+	if isServiceFactory(v.Type()) {
+		s.factory = v
+	} else {
+		s.instance = v
+	}
+	t := s.ServiceType
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isInjectField(f) {
+			continue
+		}
+		if f.PkgPath != "" {
+			return fmt.Errorf("service %s: field %s to inject is unexported", s.Name, f.Name)
+		}
+		provider, ok := s.quarkInstance.providers[f.Type]
+		if !ok {
+			return fmt.Errorf("service %s: field %s has no provider of %s", s.Name, f.Name, f.Type)
+		}
+		s.injectFields = append(s.injectFields, injectField{i, provider})
+	}
+	return nil
+}
+
+// receiver makes the per-request service value, a copy of the registered instance or a fresh one from the factory,
+// with the Console and injected fields set
+func (s *Service) receiver(c *Console) reflect.Value {
+	objV := reflect.New(s.ServiceType).Elem()
+	switch {
+	case s.factory.IsValid():
+		objV.Set(s.factory.Call(nil)[0])
+	case s.instance.IsValid():
+		objV.Set(s.instance)
+	}
+	if objV.NumField() > 0 {
+		if consoleValue := objV.Field(0); consoleValue.Type() == consoleType {
+			consoleValue.Set(reflect.ValueOf(c).Elem())
+		}
+	}
+	for _, f := range s.injectFields {
+		objV.Field(f.index).Set(f.provider.Call(nil)[0])
+	}
+	return objV
+}
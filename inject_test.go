@@ -0,0 +1,68 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type counter struct {
+	n int
+}
+
+type injectService struct {
+	Console
+	Prefix  string
+	Counter *counter `quark:",inject"`
+}
+
+func (s injectService) Hello() string {
+	s.Counter.n++
+	return s.Prefix + "hello"
+}
+
+func (s injectService) Count(c *counter) int {
+	return c.n
+}
+
+type factoryService struct {
+	Console
+	Id int
+}
+
+func (s factoryService) Number() int {
+	return s.Id
+}
+
+func TestInject(t *testing.T) {
+	q := NewQuark()
+	shared := &counter{}
+	q.Provide(func() *counter { return shared })
+	q.RegisterService(injectService{Prefix: "configured "})
+	next := 0
+	q.RegisterService(func() factoryService {
+		next++
+		return factoryService{Id: next}
+	})
+	Expect := func(path string, rsp string) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != rsp {
+			t.Errorf("%s expects %q but actual %d %q", path, rsp, w.Code, w.Body.String())
+		}
+	}
+	Expect("/injectService/hello", `"configured hello"`)
+	Expect("/injectService/hello", `"configured hello"`)
+	Expect("/injectService/count", "2")
+	Expect("/factoryService/number", "1")
+	Expect("/factoryService/number", "2")
+
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(e.(error).Error(), "no provider") {
+			t.Errorf("expects a missing provider panic but actual %v", e)
+		}
+	}()
+	NewQuark().RegisterService(injectService{})
+}
@@ -0,0 +1,191 @@
+package quark
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// Handler serves a single api call, request and response are reached through the Console
+type Handler func(c *Console)
+
+// Middleware wraps a Handler, code before next(c) runs before the api and code after it
+// can inspect the final status by c.ResponseStatus()
+type Middleware func(next Handler) Handler
+
+// ServiceMiddlewares is implemented by a service struct which wants middlewares around all of its apis
+type ServiceMiddlewares interface {
+	Middlewares() []Middleware
+}
+
+// ApiConfigurer is implemented by a service struct which wants to tune each api at registration,
+// e.g. api.Use(auth) only for some api names
+type ApiConfigurer interface {
+	ConfigureApi(api *Api)
+}
+
+var (
+	// serviceHooks are the interfaces of service structs called at registration, by method name
+	serviceHooks = map[string]reflect.Type{
+		"Middlewares":  reflect.TypeOf((*ServiceMiddlewares)(nil)).Elem(),
+		"ConfigureApi": reflect.TypeOf((*ApiConfigurer)(nil)).Elem(),
+		"Routes":       reflect.TypeOf((*RouteDefiner)(nil)).Elem(),
+		"ServiceName":  reflect.TypeOf((*ServiceNamer)(nil)).Elem(),
+		"CORS":         reflect.TypeOf((*CORSDefiner)(nil)).Elem(),
+	}
+)
+
+// reservedMethod tells whether method m of service type t is not an api, that is a Console method or a hook;
+// a method named like a hook without its signature is an error since it would be taken for the hook
+func reservedMethod(t reflect.Type, m reflect.Method) (reserved bool, e error) {
+	if hook, ok := serviceHooks[m.Name]; ok {
+		if !t.Implements(hook) {
+			return true, fmt.Errorf("method %s doesn't match %s of the hook %s", m.Name, m.Type, hook)
+		}
+		return true, nil
+	}
+	return isConsoleMethod(m), nil
+}
+
+// Use appends global middlewares, they wrap every api of every service, the first one is the outermost
+func (q *Quark) Use(mws ...Middleware) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.middlewares = append(q.middlewares, mws...)
+}
+
+// Use appends middlewares only for this api, they run inside global and service middlewares
+func (a *Api) Use(mws ...Middleware) {
+	a.middlewares = append(a.middlewares, mws...)
+}
+
+// handlerChain is the handler of an api wrapped by its middlewares, it's built again once more global
+// or api middlewares are used
+type handlerChain struct {
+	h           Handler
+	global, api int // numbers of the wrapped global and api middlewares
+}
+
+func (a *Api) handler() Handler {
+	global := a.Service().Quark().middlewares
+	if chain, _ := a.plan.chain.Load().(*handlerChain); chain != nil && chain.global == len(global) && chain.api == len(a.middlewares) {
+		return chain.h
+	}
+	h := Handler(a.serve)
+	h = wrap(h, a.middlewares)
+	h = wrap(h, a.Service().middlewares)
+	h = wrap(h, global)
+	a.plan.chain.Store(&handlerChain{h, len(global), len(a.middlewares)})
+	return h
+}
+
+// wrap applies mws around h, each layer writes its own Halt so outer middlewares still see the status
+func wrap(h Handler, mws []Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = guard(mws[i](h))
+	}
+	return h
+}
+
+func guard(h Handler) Handler {
+	return func(c *Console) {
+		defer c.recoverHalt()
+		h(c)
+	}
+}
+
+// responseWriter records the status code written by handlers so that middlewares can see it
+type responseWriter struct {
+	http.ResponseWriter
+	lock     sync.Mutex
+	status   int
+	written  int64
+	hijacked bool
+	timedOut bool
+}
+
+// Header returns a detached header once the api timed out, so late handlers don't touch the sent response
+func (w *responseWriter) Header() http.Header {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return http.Header{}
+	}
+	return w.ResponseWriter.Header()
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writeHeader(status)
+}
+
+func (w *responseWriter) writeHeader(status int) {
+	if w.status != 0 || w.hijacked || w.timedOut {
+		return
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.writeHeader(http.StatusOK)
+	}
+	n, e := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, e
+}
+
+func (w *responseWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.hijacked || w.timedOut {
+		return
+	}
+	if w.status == 0 {
+		w.writeHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// timeout writes the problem if nothing is written yet, nil problem writes nothing, then discards later writes
+func (w *responseWriter) timeout(p *Problem) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if p != nil && w.status == 0 && !w.hijacked {
+		b, _ := json.Marshal(p)
+		h := w.ResponseWriter.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Type", MediaTypeProblemJson)
+		w.writeHeader(p.Status)
+		w.ResponseWriter.Write(b)
+	}
+	w.timedOut = true
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	conn, brw, e := h.Hijack()
+	if e == nil {
+		w.hijacked = true
+	}
+	return conn, brw, e
+}
@@ -0,0 +1,79 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mwService struct {
+	Console
+}
+
+func (s mwService) Hello() string {
+	return "hello"
+}
+
+func (s mwService) Secret() string {
+	return "secret"
+}
+
+func (s mwService) Fail() {
+	s.Halt(http.StatusTeapot, "fail")
+}
+
+func (s mwService) Middlewares() []Middleware {
+	return []Middleware{trace("service")}
+}
+
+func (s mwService) ConfigureApi(api *Api) {
+	if api.Name() == "Secret" {
+		api.Use(func(next Handler) Handler {
+			return func(c *Console) {
+				if c.Request().Header.Get("Authorization") == "" {
+					c.Halt(http.StatusUnauthorized, nil)
+				}
+				next(c)
+			}
+		})
+	}
+}
+
+var traces []string
+
+func trace(name string) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Console) {
+			traces = append(traces, name+">")
+			next(c)
+			traces = append(traces, name+"<"+http.StatusText(c.ResponseStatus()))
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	q := NewQuark()
+	q.Use(trace("global"))
+	q.RegisterService(mwService{})
+	for _, api := range q.Services[0].Apis {
+		if api.Name() == "Middlewares" || api.Name() == "ConfigureApi" {
+			t.Fatalf("hook method %s registered as api", api.Name())
+		}
+	}
+
+	Expect := func(path string, status int, trace string) {
+		traces = nil
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Errorf("%s expects status %d but actual %d", path, status, w.Code)
+		}
+		if actual := strings.Join(traces, ","); actual != trace {
+			t.Errorf("%s expects trace \"%s\" but actual \"%s\"", path, trace, actual)
+		}
+	}
+	Expect("/mwService/hello", http.StatusOK, "global>,service>,service<OK,global<OK")
+	Expect("/mwService/fail", http.StatusTeapot, "global>,service>,service<I'm a teapot,global<I'm a teapot")
+	Expect("/mwService/secret", http.StatusUnauthorized, "global>,service>,service<Unauthorized,global<Unauthorized")
+}
@@ -0,0 +1,78 @@
+package quark
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/dovejb/quark/util"
+)
+
+// PathNaming converts Go identifiers into url names, it's applied to service names, path components and vars,
+// and query, header, cookie and form field names without tags, nil keeps the legacy naming which is
+// PascalToSnake for all but service names, those stay as the type names
+type PathNaming func(name string) string
+
+var (
+	// SnakeNaming names HTTPProxyStatus http_proxy_status
+	SnakeNaming PathNaming = func(name string) string {
+		return strings.Join(lowerWords(name), "_")
+	}
+	// KebabNaming names HTTPProxyStatus http-proxy-status
+	KebabNaming PathNaming = func(name string) string {
+		return strings.Join(lowerWords(name), "-")
+	}
+	// CamelNaming names HTTPProxyStatus httpProxyStatus
+	CamelNaming PathNaming = func(name string) string {
+		words := lowerWords(name)
+		for i := 1; i < len(words); i++ {
+			rs := []rune(words[i])
+			rs[0] = unicode.ToUpper(rs[0])
+			words[i] = string(rs)
+		}
+		return strings.Join(words, "")
+	}
+	// LowerNaming names HTTPProxyStatus httpproxystatus
+	LowerNaming PathNaming = func(name string) string {
+		return strings.Join(lowerWords(name), "")
+	}
+)
+
+func lowerWords(name string) []string {
+	words := util.SplitWords(name)
+	for i := range words {
+		words[i] = strings.ToLower(words[i])
+	}
+	return words
+}
+
+// element names a path component, var or field
+func (n PathNaming) element(name string) string {
+	if n == nil {
+		return util.PascalToSnake(name)
+	}
+	return n(name)
+}
+
+// service names a service by its type name
+func (n PathNaming) service(name string) string {
+	if n == nil {
+		return name
+	}
+	return n(name)
+}
+
+// field returns the quark tag name, json tag name, or the field name converted by n
+func (n PathNaming) field(f reflect.StructField) string {
+	if tag := strings.Trim(f.Tag.Get("quark"), cutset); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	if tag := strings.Trim(f.Tag.Get("json"), cutset); tag != "" && tag != "-" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return n.element(f.Name)
+}
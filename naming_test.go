@@ -0,0 +1,50 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type VehicleHTTPService struct {
+	Console
+}
+
+func (s VehicleHTTPService) VINStatus_groupID(groupID int, req struct {
+	ProxyURL String
+	Plate    String `json:"plate"`
+}) string {
+	return string(req.ProxyURL) + "," + string(req.Plate)
+}
+
+func TestPathNaming(t *testing.T) {
+	for naming, expect := range map[string]struct {
+		naming        PathNaming
+		path, query   string
+		docPath, name string
+	}{
+		"legacy": {nil, "/VehicleHTTPService/v_i_n_status/7", "proxy_u_r_l", "/VehicleHTTPService/v_i_n_status/{group_i_d}", "proxy_u_r_l"},
+		"snake":  {SnakeNaming, "/vehicle_http_service/vin_status/7", "proxy_url", "/vehicle_http_service/vin_status/{group_id}", "proxy_url"},
+		"kebab":  {KebabNaming, "/vehicle-http-service/vin-status/7", "proxy-url", "/vehicle-http-service/vin-status/{group-id}", "proxy-url"},
+		"camel":  {CamelNaming, "/vehicleHttpService/vinStatus/7", "proxyUrl", "/vehicleHttpService/vinStatus/{groupId}", "proxyUrl"},
+		"lower":  {LowerNaming, "/vehiclehttpservice/vinstatus/7", "proxyurl", "/vehiclehttpservice/vinstatus/{groupid}", "proxyurl"},
+	} {
+		q := NewQuark()
+		q.WithPathNaming(expect.naming)
+		q.RegisterService(VehicleHTTPService{})
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, expect.path+"?plate=p1&"+expect.query+"=u1", nil))
+		if w.Code != http.StatusOK || w.Body.String() != `"u1,p1"` {
+			t.Errorf("%s expects %s routed but actual %d %s", naming, expect.path, w.Code, w.Body.String())
+		}
+		item, ok := q.SwaggerSpec().Paths.Paths[expect.docPath]
+		if !ok {
+			t.Errorf("%s expects doc path %s but actual %v", naming, expect.docPath, q.SwaggerSpec().Paths.Paths)
+			continue
+		}
+		params := item.Get.Parameters
+		if len(params) != 3 || params[1].Name != expect.name || params[2].Name != "plate" {
+			t.Errorf("%s expects query %s documented but actual %v", naming, expect.name, params)
+		}
+	}
+}
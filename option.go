@@ -1,16 +1,78 @@
 package quark
 
+import "time"
+
 type AuthenticateFunc func(c *Console) bool
 
 type Option struct {
-	Authenticate AuthenticateFunc
-	PathPrefix   []string
+	Authenticate    AuthenticateFunc
+	PathPrefix      []string
+	ErrorEncoder    ErrorEncoder
+	MultipartMemory int64         // bytes of multipart parts kept in memory, the rest is spooled to temp files, 32MB by default
+	MaxUploadSize   int64         // limit of multipart request bodies, MaxBodySize is used when it's 0
+	MaxBodySize     int64         // limit of request bodies, 0 means unlimited, Api.MaxBodySize overrides it
+	SSEHeartbeat    time.Duration // interval of comment lines keeping event streams alive, 0 means no heartbeat
+	Timeout         time.Duration // deadline of each api call, 0 means none, Api.Timeout overrides it
+	Production      bool          // hides stack traces and messages of unexpected errors from responses, they are logged
+	PathNaming      PathNaming    // naming of url names, nil keeps the legacy snake_case, set it before registering services
+	TrailingSlash   TrailingSlash // how paths with a trailing slash are matched, TrailingSlashStrict by default
+	CaseInsensitive bool          // matches the static path elements and PathPrefix ignoring the case of ASCII letters
+	CORS            *CORS         // nil disables CORS, services override it by CORSDefiner
+	Docs            *Docs         // by WithDocs, nil serves no docs
+	AmbiguousRoutes bool          // registers routes shadowing part of each other's paths instead of failing, see Route.ShadowedBy
 }
 
 func (q *Quark) WithAuthenticate(f AuthenticateFunc) {
 	q.option.Authenticate = f
 }
 
+// WithAmbiguousRoutes allows routes shadowing part of each other's paths, e.g. /vehicle/vins and /vehicle/{vin},
+// the static element takes precedence, then {i}, {f} and {s}
+func (q *Quark) WithAmbiguousRoutes(allow bool) {
+	q.option.AmbiguousRoutes = allow
+}
+
 func (q *Quark) WithPathPrefix(p []string) {
 	q.option.PathPrefix = p
 }
+
+func (q *Quark) WithErrorEncoder(f ErrorEncoder) {
+	q.option.ErrorEncoder = f
+}
+
+func (q *Quark) WithMultipart(memory, maxUploadSize int64) {
+	q.option.MultipartMemory = memory
+	q.option.MaxUploadSize = maxUploadSize
+}
+
+func (q *Quark) WithMaxBodySize(n int64) {
+	q.option.MaxBodySize = n
+}
+
+func (q *Quark) WithSSEHeartbeat(interval time.Duration) {
+	q.option.SSEHeartbeat = interval
+}
+
+func (q *Quark) WithTimeout(d time.Duration) {
+	q.option.Timeout = d
+}
+
+func (q *Quark) WithProduction(production bool) {
+	q.option.Production = production
+}
+
+func (q *Quark) WithPathNaming(n PathNaming) {
+	q.option.PathNaming = n
+}
+
+func (q *Quark) WithTrailingSlash(ts TrailingSlash) {
+	q.option.TrailingSlash = ts
+}
+
+func (q *Quark) WithCaseInsensitive(insensitive bool) {
+	q.option.CaseInsensitive = insensitive
+}
+
+func (q *Quark) WithCORS(c CORS) {
+	q.option.CORS = &c
+}
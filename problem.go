@@ -0,0 +1,184 @@
+package quark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-openapi/spec"
+)
+
+const (
+	MediaTypeProblemJson = "application/problem+json"
+)
+
+// Problem is an RFC 7807 error body, Extensions are written as members beside the standard ones,
+// e.g. NewProblem(http.StatusConflict, "name is taken").With("name", name)
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// ProblemOf returns the Problem in e if any, otherwise a Problem of ErrorStatus(e) detailed by the message of e
+func ProblemOf(e error) *Problem {
+	var (
+		p  *Problem
+		ve ValidationError
+	)
+	if errors.As(e, &p) {
+		return p
+	}
+	if errors.As(e, &ve) {
+		return ve.Problem()
+	}
+	return NewProblem(ErrorStatus(e), e.Error())
+}
+
+// With sets an extension member
+func (p *Problem) With(key string, v interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = v
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+func (p *Problem) StatusCode() int {
+	return p.Status
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if e := json.Unmarshal(b, &m); e != nil {
+		return e
+	}
+	*p = Problem{}
+	for k, v := range m {
+		switch k {
+		case "type":
+			p.Type, _ = v.(string)
+		case "title":
+			p.Title, _ = v.(string)
+		case "status":
+			if f, ok := v.(float64); ok {
+				p.Status = int(f)
+			}
+		case "detail":
+			p.Detail, _ = v.(string)
+		case "instance":
+			p.Instance, _ = v.(string)
+		default:
+			p.With(k, v)
+		}
+	}
+	return nil
+}
+
+// Problem lists the failing fields in the errors member
+func (ve ValidationError) Problem() *Problem {
+	return NewProblem(http.StatusBadRequest, "validation failed").With("errors", ve)
+}
+
+// problemSchema documents the members of Problem, extensions are allowed
+func problemSchema() *spec.Schema {
+	schema := new(spec.Schema).Typed("object", "").
+		SetProperty("type", *spec.StringProperty()).
+		SetProperty("title", *spec.StringProperty()).
+		SetProperty("status", *spec.Int32Property()).
+		SetProperty("detail", *spec.StringProperty()).
+		SetProperty("instance", *spec.StringProperty())
+	schema.AdditionalProperties = &spec.SchemaOrBool{Allows: true}
+	return schema
+}
+
+// writeProblem writes p as application/problem+json, Instance defaults to the request path
+func writeProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" && r != nil {
+		cp := *p
+		cp.Instance = r.URL.Path
+		p = &cp
+	}
+	b, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", MediaTypeProblemJson)
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
+
+// panicProblem is the 500 Problem of a panic, the stack is only exposed when the Quark is not in production,
+// it's always logged
+func (q *Quark) panicProblem(value interface{}, stack []byte) *Problem {
+	log.Printf("quark: panic %v\n%s", value, stack)
+	if q.option.Production {
+		return NewProblem(http.StatusInternalServerError, "")
+	}
+	p := NewProblem(http.StatusInternalServerError, fmt.Sprintf("panic: %v", value))
+	return p.With("stack", string(stack))
+}
+
+// Halt helpers write a Problem and stop the api
+
+// HaltBadRequest halts with 400, field and message are listed in the errors member like validation failures
+func (c Console) HaltBadRequest(field, message string) {
+	c.Halt(http.StatusBadRequest, ValidationError{{Field: field, Rule: "invalid", Message: message}}.Problem())
+}
+
+func (c Console) HaltUnauthorized() {
+	c.Halt(http.StatusUnauthorized, NewProblem(http.StatusUnauthorized, ""))
+}
+
+func (c Console) HaltForbidden() {
+	c.Halt(http.StatusForbidden, NewProblem(http.StatusForbidden, ""))
+}
+
+func (c Console) HaltNotFound() {
+	c.Halt(http.StatusNotFound, NewProblem(http.StatusNotFound, ""))
+}
+
+func (c Console) HaltConflict(detail string) {
+	c.Halt(http.StatusConflict, NewProblem(http.StatusConflict, detail))
+}
@@ -0,0 +1,134 @@
+package quark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type problemService struct {
+	Console
+}
+
+func (s problemService) Missing() string {
+	s.HaltNotFound()
+	return ""
+}
+
+func (s problemService) Bad() string {
+	s.HaltBadRequest("name", "is taken")
+	return ""
+}
+
+func (s problemService) Conflict_name(name string) error {
+	return NewProblem(http.StatusConflict, "name is taken").With("name", name)
+}
+
+func (s problemService) Oops() error {
+	return errors.New("db password is wrong")
+}
+
+func (s problemService) Wrapped() error {
+	return fmt.Errorf("load: %w", NewError(http.StatusNotFound, "no vehicle"))
+}
+
+func (s problemService) WrappedProblem() error {
+	return fmt.Errorf("load: %w", NewProblem(http.StatusConflict, "locked").With("vin", "v1"))
+}
+
+func (s problemService) Crash() string {
+	panic("boom")
+}
+
+// helperNameService has apis named like the Halt helpers and the other Console methods but doesn't embed Console
+type helperNameService struct{}
+
+func (s helperNameService) NotFound() string    { return "not found" }
+func (s helperNameService) Conflict() string    { return "conflict" }
+func (s helperNameService) Get() string         { return "get" }
+func (s helperNameService) LastEventID() string { return "last" }
+func (s helperNameService) Load() string        { return "load" }
+
+// shadowHelperService embeds Console and declares NotFound, which stays an api
+type shadowHelperService struct {
+	Console
+}
+
+func (s shadowHelperService) NotFound() string { return "not found" }
+
+func TestHelperNames(t *testing.T) {
+	q := NewQuark()
+	if e := q.Register(helperNameService{}, shadowHelperService{}); e != nil {
+		t.Fatal(e)
+	}
+	for path, rsp := range map[string]string{
+		"/helperNameService/not_found":      `"not found"`,
+		"/helperNameService/conflict":       `"conflict"`,
+		"/helperNameService/get":            `"get"`,
+		"/helperNameService/last_event_i_d": `"last"`,
+		"/helperNameService/load":           `"load"`,
+		"/shadowHelperService/not_found":    `"not found"`,
+	} {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != rsp {
+			t.Errorf("%s expects 200 %s but actual %d %s", path, rsp, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestProblem(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(problemService{})
+	Expect := func(path string, status int, members map[string]interface{}) *Problem {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if ct := w.Header().Get("Content-Type"); w.Code != status || ct != MediaTypeProblemJson {
+			t.Errorf("%s expects %d %s but actual %d %s", path, status, MediaTypeProblemJson, w.Code, ct)
+		}
+		var p Problem
+		if e := json.Unmarshal(w.Body.Bytes(), &p); e != nil {
+			t.Fatal(e)
+		}
+		if p.Status != status || p.Instance != path || p.Title != http.StatusText(status) {
+			t.Errorf("%s unexpected problem %+v", path, p)
+		}
+		for k, v := range members {
+			if actual := p.Extensions[k]; !reflect.DeepEqual(actual, v) {
+				t.Errorf("%s expects member %s %v but actual %v", path, k, v, actual)
+			}
+		}
+		return &p
+	}
+	Expect("/problemService/missing", http.StatusNotFound, nil)
+	Expect("/problemService/bad", http.StatusBadRequest, map[string]interface{}{
+		"errors": []interface{}{map[string]interface{}{"field": "name", "rule": "invalid", "message": "is taken"}},
+	})
+	Expect("/problemService/conflict/bob", http.StatusConflict, map[string]interface{}{"name": "bob"})
+	if p := Expect("/problemService/oops", http.StatusInternalServerError, nil); p.Detail != "db password is wrong" {
+		t.Errorf("expects the error message as detail but actual %q", p.Detail)
+	}
+	if p := Expect("/problemService/crash", http.StatusInternalServerError, nil); p.Detail != "panic: boom" || p.Extensions["stack"] == nil {
+		t.Errorf("expects the panic and its stack but actual %+v", p)
+	}
+	if p := Expect("/problemService/wrapped", http.StatusNotFound, nil); p.Detail != "load: no vehicle" {
+		t.Errorf("expects the wrapped error message as detail but actual %q", p.Detail)
+	}
+	Expect("/problemService/wrapped_problem", http.StatusConflict, map[string]interface{}{"vin": "v1"})
+
+	q.WithProduction(true)
+	if p := Expect("/problemService/oops", http.StatusInternalServerError, nil); p.Detail != "" {
+		t.Errorf("expects the message hidden in production but actual %q", p.Detail)
+	}
+	if p := Expect("/problemService/crash", http.StatusInternalServerError, nil); p.Detail != "" || p.Extensions["stack"] != nil {
+		t.Errorf("expects the stack hidden in production but actual %+v", p)
+	}
+	Expect("/problemService/conflict/bob", http.StatusConflict, map[string]interface{}{"name": "bob"})
+	if p := Expect("/problemService/wrapped", http.StatusNotFound, nil); p.Detail != "load: no vehicle" {
+		t.Errorf("expects the wrapped HTTPError message kept in production but actual %q", p.Detail)
+	}
+}
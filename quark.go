@@ -3,9 +3,11 @@ package quark
 import "encoding/json"
 
 func NewQuark() *Quark {
-	return &Quark{
+	q := &Quark{
 		Marshal:   json.Marshal,
 		Unmarshal: json.Unmarshal,
 		option:    &Option{},
 	}
+	q.registerDefaultCodecs()
+	return q
 }
@@ -0,0 +1,315 @@
+package quark
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TrailingSlash decides how a path with a trailing slash is matched, e.g. /vehicle/status/ against /vehicle/status
+type TrailingSlash int
+
+const (
+	TrailingSlashStrict   TrailingSlash = iota // the path matches no api, by default
+	TrailingSlashIgnore                        // the path is served by the api without the slash
+	TrailingSlashRedirect                      // the path is redirected to the one without the slash, 301 for GET and 308 for others
+)
+
+// router matches request paths to apis by a radix tree, the static bytes shared by routes are compressed into
+// single nodes and vars match whole path elements of their kinds.
+// At every node static children are tried first, then {i}, {f} and {s}, backtracking when the rest of the path
+// doesn't match, so the precedence is the same as the order of choices of PathElementChoices.
+type router struct {
+	root    node
+	maxVars int
+	vars    sync.Pool // *[]string receiving path var values, so matching doesn't allocate
+}
+
+type node struct {
+	prefix  string     // static bytes of the node, blank for the root and var nodes
+	statics []*node    // static children, their prefixes start with distinct bytes
+	vars    [3]*node   // children of {i}, {f} and {s}
+	apis    []routeApi // apis whose routes end at the node
+	allow   string     // Allow header of the methods of apis, with the automatic HEAD and OPTIONS
+}
+
+type routeApi struct {
+	method string // blank for any
+	api    *Api
+	depth  int // segments of the mount path, the deepest mount takes a route of several services
+}
+
+var varElements = [...]string{"{i}", "{f}", "{s}"}
+
+// newRouter builds the tree of the apis of services, a route is the mount path followed by the api path
+func newRouter(services []Service) *router {
+	rt := new(router)
+	for i := range services {
+		s := &services[i]
+		depth := 0
+		if s.Name != ROOT_SERVICE_NAME {
+			depth = strings.Count(s.Name, "/") + 1
+		}
+		for j := range s.Apis {
+			a := &s.Apis[j]
+			rt.root.insert(s.mountPath()+a.Path, routeApi{a.Method, a, depth})
+			if len(a.PathVars) > rt.maxVars {
+				rt.maxVars = len(a.PathVars)
+			}
+		}
+	}
+	n := rt.maxVars
+	rt.vars.New = func() interface{} {
+		vars := make([]string, 0, n)
+		return &vars
+	}
+	return rt
+}
+
+func (n *node) insert(path string, ra routeApi) {
+	for path != "" {
+		if path[0] == '{' {
+			end := strings.IndexByte(path, '}') + 1
+			k := 0
+			for varElements[k] != path[:end] {
+				k++
+			}
+			if n.vars[k] == nil {
+				n.vars[k] = new(node)
+			}
+			n, path = n.vars[k], path[end:]
+			continue
+		}
+		end := strings.IndexByte(path, '{')
+		if end < 0 {
+			end = len(path)
+		}
+		n, path = n.insertStatic(path[:end]), path[end:]
+	}
+	for i := range n.apis {
+		if n.apis[i].method == ra.method {
+			if ra.depth > n.apis[i].depth {
+				n.apis[i] = ra
+				n.allow = n.allowed()
+			}
+			return
+		}
+	}
+	n.apis = append(n.apis, ra)
+	n.allow = n.allowed()
+}
+
+// allowOrder is the order of methods in Allow headers
+var allowOrder = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// allowed lists the methods served at the node, every one if some api accepts any method
+func (n *node) allowed() string {
+	var methods []string
+	for _, m := range allowOrder {
+		if m == http.MethodOptions || n.api(m) != nil {
+			methods = append(methods, m)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// insertStatic descends along s, splitting the nodes sharing part of it, and returns the node where s ends
+func (n *node) insertStatic(s string) *node {
+	for s != "" {
+		var child *node
+		for _, c := range n.statics {
+			if c.prefix[0] == s[0] {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &node{prefix: s}
+			n.statics = append(n.statics, child)
+			return child
+		}
+		common := 0
+		for common < len(s) && common < len(child.prefix) && s[common] == child.prefix[common] {
+			common++
+		}
+		if common < len(child.prefix) {
+			rest := *child
+			rest.prefix = child.prefix[common:]
+			*child = node{prefix: child.prefix[:common], statics: []*node{&rest}}
+		}
+		n, s = child, s[common:]
+	}
+	return n
+}
+
+// lookup walks the tree depth first for the api of method, vars receives the path var values in order.
+// first is set to the first node where path ends with apis of any method, for reporting the allowed methods
+func (n *node) lookup(path, method string, fold bool, vars *[]string, first **node) *Api {
+	if path == "" {
+		if len(n.apis) == 0 {
+			return nil
+		}
+		if *first == nil {
+			*first = n
+		}
+		return n.api(method)
+	}
+	for _, c := range n.statics {
+		if hasPrefix(path, c.prefix, fold) {
+			if a := c.lookup(path[len(c.prefix):], method, fold, vars, first); a != nil {
+				return a
+			}
+		}
+	}
+	if n.vars == [3]*node{} {
+		return nil
+	}
+	elem := path
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		elem = path[:i]
+	}
+	if elem == "" {
+		return nil
+	}
+	isInt, isFloat := numberKinds(elem)
+	for k, c := range n.vars {
+		if c == nil || k == 0 && !isInt || k == 1 && !isFloat {
+			continue
+		}
+		*vars = append(*vars, elem)
+		if a := c.lookup(path[len(elem):], method, fold, vars, first); a != nil {
+			return a
+		}
+		*vars = (*vars)[:len(*vars)-1]
+	}
+	return nil
+}
+
+// api returns the api of method, or the one accepting any method, HEAD falls back to the GET api
+func (n *node) api(method string) *Api {
+	var any, get *Api
+	for i := range n.apis {
+		switch n.apis[i].method {
+		case method:
+			return n.apis[i].api
+		case "":
+			any = n.apis[i].api
+		case http.MethodGet:
+			get = n.apis[i].api
+		}
+	}
+	if any == nil && method == http.MethodHead && get != nil && !get.longLived() {
+		return get
+	}
+	return any
+}
+
+// numberKinds tells whether elem is matched by {i} and {f}, the same as PathElementChoices
+func numberKinds(elem string) (isInt, isFloat bool) {
+	isInt, isFloat = true, true
+	dots := 0
+	for _, r := range elem {
+		switch {
+		case unicode.IsLetter(r):
+			return false, false
+		case unicode.IsSymbol(r) || unicode.IsPunct(r) || unicode.IsSpace(r):
+			isInt = false
+			if r == '.' {
+				if dots++; dots > 1 {
+					isFloat = false
+				}
+			}
+		case !unicode.IsNumber(r):
+			return false, false
+		}
+	}
+	return
+}
+
+// hasPrefix is strings.HasPrefix ignoring the case of ASCII letters when fold
+func hasPrefix(s, prefix string, fold bool) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	if !fold {
+		return s[:len(prefix)] == prefix
+	}
+	for i := 0; i < len(prefix); i++ {
+		if lowerASCII(s[i]) != lowerASCII(prefix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// route finds the api of method and path, vars receives the path var values.
+// redirect is the path to redirect to by Option.TrailingSlash, allowed is the node of the path when it has
+// apis of other methods only
+func (q *Quark) route(method, path string, vars *[]string) (api *Api, allowed *node, redirect string) {
+	rt := q.router
+	if rt == nil {
+		return
+	}
+	fold := q.option.CaseInsensitive
+	rest, ok := q.stripPrefix(path, fold)
+	if !ok {
+		return
+	}
+	if api = rt.root.lookup(rest, method, fold, vars, &allowed); api != nil || allowed != nil {
+		return
+	}
+	if len(rest) < 2 || rest[len(rest)-1] != '/' || q.option.TrailingSlash == TrailingSlashStrict {
+		return
+	}
+	if api = rt.root.lookup(rest[:len(rest)-1], method, fold, vars, &allowed); api == nil && allowed == nil {
+		return
+	}
+	if q.option.TrailingSlash == TrailingSlashRedirect {
+		return nil, nil, path[:len(path)-1]
+	}
+	return
+}
+
+// stripPrefix trims Option.PathPrefix from path, ok is false if path doesn't start with it
+func (q *Quark) stripPrefix(path string, fold bool) (rest string, ok bool) {
+	for _, p := range q.option.PathPrefix {
+		if len(path) < 1+len(p) || path[0] != '/' || !hasPrefix(path[1:], p, fold) {
+			return "", false
+		}
+		if path = path[1+len(p):]; path != "" && path[0] != '/' {
+			return "", false
+		}
+	}
+	return path, true
+}
+
+// redirectTrailingSlash redirects r to path keeping the query, GET by 301 and others by 308 keeping the method and body
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request, path string) {
+	status := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet {
+		status = http.StatusMovedPermanently
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, path, status)
+}
+
+// headWriter answers HEAD requests by GET apis, the headers are written and the body is discarded
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
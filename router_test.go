@@ -0,0 +1,266 @@
+package quark
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dovejb/quark/util"
+)
+
+type routerService struct {
+	Console
+}
+
+func (s routerService) Routes() map[string]string {
+	return map[string]string{
+		"Latest":  "GET /item/latest",
+		"ByInt":   "GET /item/{id:i}",
+		"ByFloat": "GET /item/{x:f}",
+		"ByName":  "GET /item/{name}",
+		"Parts":   "GET /item/{id:i}/parts",
+		"Owner":   "GET /item/{name}/owner",
+	}
+}
+
+func (s routerService) Latest() string                { return "latest" }
+func (s routerService) ByInt(id int) string           { return fmt.Sprint("int ", id) }
+func (s routerService) ByFloat(x float64) string      { return fmt.Sprint("float ", x) }
+func (s routerService) ByName(name string) string     { return "name " + name }
+func (s routerService) Parts(id int) string           { return fmt.Sprint("parts ", id) }
+func (s routerService) Owner(name string) string      { return "owner " + name }
+func (s routerService) POST_Item(req struct{}) string { return "post" }
+
+type shadowedService struct {
+	Console
+}
+
+func (s shadowedService) Routes() map[string]string {
+	return map[string]string{"Latest": "GET /routerService/{kind}/latest"}
+}
+
+func (s shadowedService) Latest(kind string) string { return "root " + kind }
+
+func TestRouter(t *testing.T) {
+	q := NewQuark()
+	q.WithAmbiguousRoutes(true)
+	q.RegisterService(routerService{})
+	if e := q.Mount("", shadowedService{}); e != nil {
+		t.Fatal(e)
+	}
+	Expect := func(method, path string, status int, rsp string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != status || status == http.StatusOK && w.Body.String() != `"`+rsp+`"` {
+			t.Errorf("%s %s expects %d %s but actual %d %s", method, path, status, rsp, w.Code, w.Body.String())
+		}
+	}
+	Expect("GET", "/routerService/item/latest", 200, "latest")
+	Expect("GET", "/routerService/part/latest", 200, "root part")
+	Expect("GET", "/routerService/item/12", 200, "int 12")
+	Expect("GET", "/routerService/item/1.5", 200, "float 1.5")
+	Expect("GET", "/routerService/item/-3", 200, "float -3")
+	Expect("GET", "/routerService/item/car", 200, "name car")
+	Expect("GET", "/routerService/item/12/parts", 200, "parts 12")
+	Expect("GET", "/routerService/item/12/owner", 200, "owner 12")
+	Expect("POST", "/routerService/item", 200, "post")
+	Expect("GET", "/routerService/item/12/", http.StatusNotFound, "")
+	Expect("GET", "/ROUTERSERVICE/item/latest", http.StatusNotFound, "")
+
+	q.WithTrailingSlash(TrailingSlashIgnore)
+	q.WithCaseInsensitive(true)
+	Expect("GET", "/routerService/item/12/", 200, "int 12")
+	Expect("GET", "/ROUTERSERVICE/Item/Car", 200, "name Car")
+
+	q.WithTrailingSlash(TrailingSlashRedirect)
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest("GET", "/routerService/item/12/?x=1", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/routerService/item/12?x=1" {
+		t.Errorf("expects redirect to /routerService/item/12?x=1 but actual %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest("POST", "/routerService/item/", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/routerService/item" {
+		t.Errorf("expects redirect to /routerService/item but actual %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
+
+// benchRoutes are 360 routes of 60 services, half of them mounted in a version group
+func benchRoutes() (routes [][2]string, paths []string) {
+	for i := 0; i < 60; i++ {
+		mount := fmt.Sprintf("/fleet%d", i)
+		if i%2 == 1 {
+			mount = "/v1" + mount
+		}
+		for _, r := range [][2]string{
+			{"GET", "/vehicles"},
+			{"GET", "/vehicles/{i}"},
+			{"POST", "/vehicles/{i}/lock"},
+			{"GET", "/vehicles/{s}/status"},
+			{"", "/search/{s}/{f}"},
+			{"GET", "/drivers/{i}/trips/{i}"},
+		} {
+			routes = append(routes, [2]string{r[0], mount + r[1]})
+		}
+		paths = append(paths, mount+"/vehicles/1234", mount+"/vehicles/VIN9/status", mount+"/drivers/12/trips/99", mount+"/search/car/1.5")
+	}
+	return
+}
+
+// legacyRouter is the lookup replaced by router, a util.Trie per service found by joined path elements
+type legacyRouter struct {
+	services   map[string]util.Trie
+	mountDepth int
+}
+
+func newLegacyRouter(routes [][2]string) *legacyRouter {
+	l := &legacyRouter{services: make(map[string]util.Trie)}
+	for i, r := range routes {
+		elems := strings.Split(r[1][1:], "/")
+		k := 1
+		if elems[0] == "v1" {
+			k = 2
+		}
+		mount := strings.Join(elems[:k], "/")
+		trie, ok := l.services[mount]
+		if !ok {
+			trie = util.NewTrie()
+			l.services[mount] = trie
+		}
+		trie.Add(append(elems[k:], ":"+r[0]), i)
+		if k > l.mountDepth {
+			l.mountDepth = k
+		}
+	}
+	return l
+}
+
+func (l *legacyRouter) lookup(method, path string) (int, bool) {
+	elems := strings.Split(path[1:], "/")
+	for k := l.mountDepth; k > 0; k-- {
+		if k > len(elems) {
+			continue
+		}
+		if trie, ok := l.services[strings.Join(elems[:k], "/")]; ok {
+			if methodTrie := legacyRoute(elems[k:], trie); methodTrie.Valid() {
+				index, ok := methodTrie.Find([]string{":" + method})
+				if !ok {
+					index, ok = methodTrie.Find([]string{":"})
+				}
+				if ok {
+					return *index.Value(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func legacyRoute(elems []string, trie util.Trie) util.Trie {
+	if len(elems) == 0 {
+		return trie
+	}
+	for _, choice := range PathElementChoices(elems[0]) {
+		if sub := trie.Sub(choice); sub.Valid() {
+			if candidate := legacyRoute(elems[1:], sub); candidate.Valid() {
+				return candidate
+			}
+		}
+	}
+	return util.NilTrie
+}
+
+func newBenchRouter(routes [][2]string) (*router, []Api) {
+	rt := new(router)
+	apis := make([]Api, len(routes))
+	for i, r := range routes {
+		apis[i].Method = r[0]
+		rt.root.insert(r[1], routeApi{r[0], &apis[i], 0})
+	}
+	return rt, apis
+}
+
+func TestRouterMatchesLegacy(t *testing.T) {
+	routes, paths := benchRoutes()
+	rt, apis := newBenchRouter(routes)
+	legacy := newLegacyRouter(routes)
+	vars := make([]string, 0, 4)
+	for _, path := range paths {
+		var allowed *node
+		api := rt.root.lookup(path, "GET", false, &vars, &allowed)
+		index, ok := legacy.lookup("GET", path)
+		if !ok || api != &apis[index] {
+			t.Errorf("%s doesn't match route %d of the legacy lookup", path, index)
+		}
+		vars = vars[:0]
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		var allowed *node
+		rt.root.lookup(paths[7], "GET", false, &vars, &allowed)
+		vars = vars[:0]
+	}); n != 0 {
+		t.Errorf("expects matching without allocation but actual %v allocs", n)
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	routes, paths := benchRoutes()
+	rt, _ := newBenchRouter(routes)
+	vars := make([]string, 0, 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var allowed *node
+		if rt.root.lookup(paths[i%len(paths)], "GET", false, &vars, &allowed) == nil {
+			b.Fatal(paths[i%len(paths)])
+		}
+		vars = vars[:0]
+	}
+}
+
+func BenchmarkLegacyTrie(b *testing.B) {
+	routes, paths := benchRoutes()
+	legacy := newLegacyRouter(routes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := legacy.lookup("GET", paths[i%len(paths)]); !ok {
+			b.Fatal(paths[i%len(paths)])
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	q := NewQuark()
+	q.WithAmbiguousRoutes(true)
+	q.RegisterService(routerService{})
+	Expect := func(method, path string, status int, allow, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != status || w.Header().Get("Allow") != allow || body != "*" && w.Body.String() != body {
+			t.Errorf("%s %s expects %d %q %q but actual %d %q %q", method, path, status, allow, body, w.Code, w.Header().Get("Allow"), w.Body.String())
+		}
+	}
+	Expect("DELETE", "/routerService/item/latest", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS", "*")
+	Expect("GET", "/routerService/item", http.StatusMethodNotAllowed, "POST, OPTIONS", "*")
+	Expect("HEAD", "/routerService/item/latest", http.StatusOK, "", "")
+	Expect("OPTIONS", "/routerService/item/12", http.StatusNoContent, "GET, HEAD, OPTIONS", "")
+	Expect("OPTIONS", "/routerService/item", http.StatusNoContent, "POST, OPTIONS", "")
+	Expect("OPTIONS", "*", http.StatusNoContent, "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS", "")
+	Expect("GET", "/routerService/missing", http.StatusNotFound, "", "*")
+
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest("HEAD", "/routerService/item/latest", nil))
+	if w.Header().Get("Content-Type") != MediaTypeJson {
+		t.Errorf("expects HEAD with the headers of GET but actual %v", w.Header())
+	}
+	w = httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest("PUT", "/routerService/item", nil))
+	if ct := w.Header().Get("Content-Type"); ct != MediaTypeProblemJson {
+		t.Errorf("expects 405 as problem but actual %s", ct)
+	}
+}
@@ -0,0 +1,278 @@
+package quark
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dovejb/quark/util"
+)
+
+// Route is a row of the route table
+type Route struct {
+	Method   string // ANY for apis accepting every method
+	Path     string // full path with the prefix, service name and named vars
+	Handler  string // Service.Method
+	Request  reflect.Type
+	Response reflect.Type
+	// ShadowedBy lists the handlers matched before this one on the paths both match,
+	// such ambiguous routes are only registered with Option.AmbiguousRoutes
+	ShadowedBy []string
+}
+
+// RouteTable is sorted by path then method, its String is aligned for printing at startup
+type RouteTable []Route
+
+func (t RouteTable) String() string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tHANDLER\tREQUEST\tRESPONSE\tSHADOWED BY")
+	for _, r := range t {
+		shadowedBy := "-"
+		if len(r.ShadowedBy) > 0 {
+			shadowedBy = strings.Join(r.ShadowedBy, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Method, r.Path, r.Handler, typeName(r.Request), typeName(r.Response), shadowedBy)
+	}
+	tw.Flush()
+	return buf.String()
+}
+
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return "-"
+	}
+	return t.String()
+}
+
+// Routes returns the route table of all registered apis
+func (q *Quark) Routes() RouteTable {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var t RouteTable
+	for i := range q.Services {
+		s := &q.Services[i]
+		for j := range s.Apis {
+			a := &s.Apis[j]
+			t = append(t, Route{
+				Method:     a.methodName(),
+				Path:       q.servicePath(s) + a.docPath,
+				Handler:    s.ServiceType.Name() + "." + a.Name(),
+				Request:    a.Request,
+				Response:   a.Response,
+				ShadowedBy: a.shadowedBy,
+			})
+		}
+	}
+	sort.Slice(t, func(i, j int) bool {
+		if t[i].Path != t[j].Path {
+			return t[i].Path < t[j].Path
+		}
+		return t[i].Method < t[j].Method
+	})
+	return t
+}
+
+// servicePath is the path prefix of the apis of s
+func (q *Quark) servicePath(s *Service) string {
+	if len(q.option.PathPrefix) == 0 {
+		return s.mountPath()
+	}
+	return "/" + strings.Join(q.option.PathPrefix, "/") + s.mountPath()
+}
+
+// mountPath is the path of s without Option.PathPrefix, blank for the root service
+func (s *Service) mountPath() string {
+	if s.Name == ROOT_SERVICE_NAME {
+		return ""
+	}
+	return "/" + s.Name
+}
+
+func isVarElement(elem string) bool {
+	return strings.HasPrefix(elem, "{")
+}
+
+// elementsOverlap tells whether some path element is matched by both route elements
+func elementsOverlap(a, b string) bool {
+	if a == b {
+		return true
+	}
+	av, bv := isVarElement(a), isVarElement(b)
+	switch {
+	case !av && !bv:
+		return false
+	case av && bv: // numbers are matched by {i}, {f} and {s}
+		return true
+	case av:
+		a, b = b, a
+	}
+	for _, choice := range PathElementChoices(a) {
+		if choice == b {
+			return true
+		}
+	}
+	return false
+}
+
+// elementRank is the order Route tries the element
+func elementRank(elem string) int {
+	switch elem {
+	case "{i}":
+		return 1
+	case "{f}":
+		return 2
+	case "{s}":
+		return 3
+	}
+	return 0
+}
+
+// routedApi is an api with its path below Option.PathPrefix and its handler name, for checkRoutes
+type routedApi struct {
+	*Api
+	path, handler string
+	added         bool // of the services being registered
+}
+
+// checkRoutes compares the full paths of the apis of all services with the ones being added, it fails on apis
+// with the same method and path, and on apis shadowing part of each other's paths unless Option.AmbiguousRoutes;
+// the shadowing apis are recorded for the route table once the services are registered
+func (q *Quark) checkRoutes(added []*Service) (shadowedBy map[*Api][]string, errs RegisterError) {
+	var apis []routedApi
+	collect := func(s *Service, added bool) {
+		for j := range s.Apis {
+			a := &s.Apis[j]
+			apis = append(apis, routedApi{a, s.mountPath() + a.Path, s.ServiceType.Name() + "." + a.Name(), added})
+		}
+	}
+	for i := range q.Services {
+		collect(&q.Services[i], false)
+	}
+	for _, s := range added {
+		collect(s, true)
+	}
+	shadowedBy = make(map[*Api][]string)
+	for i := range apis {
+		for j := i + 1; j < len(apis); j++ {
+			a, b := &apis[i], &apis[j]
+			if a.Method != b.Method && a.Method != "" && b.Method != "" {
+				continue
+			}
+			report := a.added || b.added
+			if a.path == b.path {
+				if a.Method == b.Method && report {
+					errs = append(errs, fmt.Errorf("duplicate route %s %s by %s and %s", a.methodName(), a.path, a.handler, b.handler))
+				}
+				continue
+			}
+			ae, be := strings.Split(a.path, "/"), strings.Split(b.path, "/")
+			if len(ae) != len(be) {
+				continue
+			}
+			overlap, first := true, -1
+			for k := range ae {
+				if !elementsOverlap(ae[k], be[k]) {
+					overlap = false
+					break
+				}
+				if first < 0 && ae[k] != be[k] {
+					first = k
+				}
+			}
+			if !overlap {
+				continue
+			}
+			if elementRank(ae[first]) > elementRank(be[first]) {
+				a, b = b, a
+			}
+			shadowedBy[b.Api] = append(shadowedBy[b.Api], a.handler)
+			if report && !q.option.AmbiguousRoutes {
+				errs = append(errs, fmt.Errorf("ambiguous routes, %s %s (%s) takes precedence over %s %s (%s) on the paths both match",
+					a.methodName(), a.path, a.handler, b.methodName(), b.path, b.handler))
+			}
+		}
+	}
+	return
+}
+
+func (a *Api) methodName() string {
+	if a.Method == "" {
+		return "ANY"
+	}
+	return a.Method
+}
+
+// RouteDefiner is implemented by a service struct which overrides the derived routes of some apis, e.g.
+// map[string]string{"Status": "GET /vehicles/{vin:s}/status"}, the method is optional and blank means any,
+// vars are {name:s}, {name:i}, {name:f} or {name} typed by the parameter, in the order of path var parameters
+type RouteDefiner interface {
+	Routes() map[string]string
+}
+
+var routeVarTypes = map[string]reflect.Kind{
+	"s": reflect.String,
+	"i": reflect.Int,
+	"f": reflect.Float64,
+}
+
+// parseRoute parses a route of RouteDefiner into the method, the trie path and path vars like FuncNameToPathWithVars
+func parseRoute(route string, varsType []reflect.Kind) (method, path string, vars []util.PathVar, e error) {
+	route = strings.TrimSpace(route)
+	if fields := strings.Fields(route); len(fields) == 2 {
+		method, route = strings.ToUpper(fields[0]), fields[1]
+		if method == "ANY" {
+			method = ""
+		} else if !validMethods[method] {
+			return "", "", nil, fmt.Errorf("unknown method %s", method)
+		}
+	} else if len(fields) != 1 {
+		return "", "", nil, fmt.Errorf("expects [METHOD] /path")
+	}
+	if !strings.HasPrefix(route, "/") || len(route) < 2 {
+		return "", "", nil, fmt.Errorf("path must start with / and not be empty")
+	}
+	for pos, elem := range strings.Split(route[1:], "/") {
+		if elem == "" {
+			return "", "", nil, fmt.Errorf("empty path element at %d", pos)
+		}
+		if !strings.HasPrefix(elem, "{") {
+			if strings.ContainsAny(elem, "{}") {
+				return "", "", nil, fmt.Errorf("invalid path element %s", elem)
+			}
+			path += "/" + elem
+			continue
+		}
+		if !strings.HasSuffix(elem, "}") {
+			return "", "", nil, fmt.Errorf("invalid path var %s", elem)
+		}
+		if len(vars) >= len(varsType) {
+			return "", "", nil, fmt.Errorf("path var num > path var parameter num")
+		}
+		kind := varsType[len(vars)]
+		nameAndType := strings.SplitN(elem[1:len(elem)-1], ":", 2)
+		if nameAndType[0] == "" {
+			return "", "", nil, fmt.Errorf("path var %s has no name", elem)
+		}
+		if len(nameAndType) > 1 {
+			if k, ok := routeVarTypes[nameAndType[1]]; !ok || k != kind {
+				return "", "", nil, fmt.Errorf("path var %s doesn't match the %s parameter", elem, kind)
+			}
+		}
+		typeChar := "s"
+		for t, k := range routeVarTypes {
+			if k == kind {
+				typeChar = t
+			}
+		}
+		path += "/{" + typeChar + "}"
+		vars = append(vars, util.PathVar{Pos: pos, Var: typeChar + "." + nameAndType[0]})
+	}
+	if len(vars) != len(varsType) {
+		return "", "", nil, fmt.Errorf("path var num != path var parameter num")
+	}
+	return
+}
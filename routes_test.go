@@ -0,0 +1,166 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type routeService struct {
+	Console
+}
+
+func (s routeService) Vehicle_Vins() string {
+	return "list"
+}
+
+func (s routeService) Vehicle_vin(vin string) string {
+	return vin
+}
+
+func (s routeService) POST_Vehicle_id(id int, req struct{ Name string }) {}
+
+type duplicateRouteService struct {
+	Console
+}
+
+func (s duplicateRouteService) Vehicle_id(id int) {}
+
+func (s duplicateRouteService) Vehicle_no(no int) {}
+
+type fleetListService struct {
+	Console
+}
+
+func (s fleetListService) Vehicles_List() string { return "fleet" }
+
+type vehicleListService struct {
+	Console
+}
+
+func (s vehicleListService) List() string { return "vehicles" }
+
+type rootListService struct {
+	Console
+}
+
+func (s rootListService) Fleet_Vehicles_List() string { return "root" }
+
+func TestRoutes(t *testing.T) {
+	e := NewQuark().Register(routeService{})
+	for _, expect := range []string{
+		"ANY /routeService/vehicle/vins (routeService.Vehicle_Vins) takes precedence over ANY /routeService/vehicle/{s} (routeService.Vehicle_vin)",
+		"POST /routeService/vehicle/{i} (routeService.POST_Vehicle_id) takes precedence over ANY /routeService/vehicle/{s} (routeService.Vehicle_vin)",
+	} {
+		if e == nil || !strings.Contains(e.Error(), expect) {
+			t.Errorf("expects %s reported but actual %v", expect, e)
+		}
+	}
+
+	q := NewQuark()
+	q.WithPathPrefix([]string{"api"})
+	q.WithAmbiguousRoutes(true)
+	q.RegisterService(routeService{})
+	routes := q.Routes()
+	expects := []Route{
+		{"ANY", "/api/routeService/vehicle/vins", "routeService.Vehicle_Vins", nil, reflect.TypeOf(""), nil},
+		{http.MethodPost, "/api/routeService/vehicle/{id}", "routeService.POST_Vehicle_id", reflect.TypeOf(struct{ Name string }{}), nil, nil},
+		{"ANY", "/api/routeService/vehicle/{vin}", "routeService.Vehicle_vin", nil, reflect.TypeOf(""),
+			[]string{"routeService.POST_Vehicle_id", "routeService.Vehicle_Vins"}},
+	}
+	if !reflect.DeepEqual([]Route(routes), expects) {
+		t.Errorf("expects routes %v but actual %v", expects, routes)
+	}
+	if lines := strings.Split(strings.TrimSpace(routes.String()), "\n"); len(lines) != 4 || !strings.HasPrefix(lines[0], "METHOD") {
+		t.Errorf("unexpected route table\n%s", routes)
+	}
+
+	e = NewQuark().Register(duplicateRouteService{})
+	if e == nil || !strings.Contains(e.Error(), "ANY /duplicateRouteService/vehicle/{i} by duplicateRouteService.Vehicle_id and duplicateRouteService.Vehicle_no") {
+		t.Errorf("expects duplicate routes reported but actual %v", e)
+	}
+
+	q = NewQuark()
+	q.WithAmbiguousRoutes(true)
+	if e := q.Mount("fleet", fleetListService{}); e != nil {
+		t.Fatal(e)
+	}
+	e = q.Mount("fleet/vehicles", vehicleListService{})
+	if e == nil || !strings.Contains(e.Error(), "duplicate route ANY /fleet/vehicles/list by fleetListService.Vehicles_List and vehicleListService.List") {
+		t.Errorf("expects the duplicate route of another service reported but actual %v", e)
+	}
+	e = q.Mount("", rootListService{})
+	if e == nil || !strings.Contains(e.Error(), "duplicate route ANY /fleet/vehicles/list by fleetListService.Vehicles_List and rootListService.Fleet_Vehicles_List") {
+		t.Errorf("expects the duplicate route of the root service reported but actual %v", e)
+	}
+	if routes := q.Routes(); len(routes) != 1 {
+		t.Errorf("expects services with duplicate routes not registered but actual\n%s", routes)
+	}
+}
+
+type routeOverrideService struct {
+	Console
+}
+
+func (s routeOverrideService) Routes() map[string]string {
+	return map[string]string{
+		"Status": "GET /vehicles/{vin:s}/status",
+		"Group":  "/vehicle-groups/{id}",
+		"Type":   "post /type",
+	}
+}
+
+func (s routeOverrideService) Status(vin string) string {
+	return vin + " ok"
+}
+
+func (s routeOverrideService) Group(id int) int {
+	return id
+}
+
+func (s routeOverrideService) Type() string {
+	return "type"
+}
+
+type badRouteService struct {
+	Console
+}
+
+func (s badRouteService) Routes() map[string]string {
+	return map[string]string{
+		"Find":    "GET /find/{id:s}",
+		"Missing": "/missing",
+	}
+}
+
+func (s badRouteService) Find(id int) {}
+
+func TestRouteOverrides(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(routeOverrideService{})
+	Expect := func(method, path string, status int, rsp string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != status || (status == http.StatusOK && w.Body.String() != rsp) {
+			t.Errorf("%s %s expects %d %s but actual %d %s", method, path, status, rsp, w.Code, w.Body.String())
+		}
+	}
+	Expect(http.MethodGet, "/routeOverrideService/vehicles/v1/status", http.StatusOK, `"v1 ok"`)
+	Expect(http.MethodPost, "/routeOverrideService/vehicles/v1/status", http.StatusMethodNotAllowed, "")
+	Expect(http.MethodGet, "/routeOverrideService/vehicle-groups/7", http.StatusOK, "7")
+	Expect(http.MethodPost, "/routeOverrideService/type", http.StatusOK, `"type"`)
+	Expect(http.MethodGet, "/routeOverrideService/status", http.StatusNotFound, "")
+
+	paths := q.SwaggerSpec().Paths.Paths
+	if _, ok := paths["/routeOverrideService/vehicles/{vin}/status"]; !ok {
+		t.Errorf("expects the overridden path documented but actual %v", paths)
+	}
+
+	e := NewQuark().Register(badRouteService{})
+	if e == nil || !strings.Contains(e.Error(), "path var {id:s} doesn't match the int parameter") ||
+		!strings.Contains(e.Error(), "route of Missing has no api method") {
+		t.Errorf("expects invalid routes reported but actual %v", e)
+	}
+}
@@ -0,0 +1,199 @@
+package quark
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MediaTypeEventStream = "text/event-stream"
+)
+
+// Event is a server-sent event, items of other types sent to an event stream become the Data of an Event
+type Event struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// EventStream is injected as a method parameter to push server-sent events, e.g.
+// func (s svc) Watch(es quark.EventStream) { for { es.Send(x) } }, the stream ends when the method returns
+type EventStream struct {
+	s *sseWriter
+}
+
+var (
+	eventStreamType = reflect.TypeOf(EventStream{})
+)
+
+// Send pushes v as an Event or the data of an Event
+func (es EventStream) Send(v interface{}) error {
+	return es.s.send(v)
+}
+
+// LastEventID returns the Last-Event-ID header sent by a reconnecting client
+func (es EventStream) LastEventID() string {
+	return es.s.r.Header.Get("Last-Event-ID")
+}
+
+// Done is closed when the client goes away
+func (es EventStream) Done() <-chan struct{} {
+	return es.s.r.Context().Done()
+}
+
+type sseWriter struct {
+	lock    sync.Mutex
+	w       *responseWriter
+	r       *http.Request
+	marshal JsonMarshalFunc
+}
+
+func newSseWriter(c *Console) *sseWriter {
+	s := &sseWriter{
+		w:       c.w,
+		r:       c.r,
+		marshal: c.quark.Marshal,
+	}
+	h := c.w.Header()
+	h.Set("Content-Type", MediaTypeEventStream)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	c.w.WriteHeader(http.StatusOK)
+	c.w.Flush()
+	return s
+}
+
+func (s *sseWriter) send(v interface{}) error {
+	if e := s.r.Context().Err(); e != nil {
+		return e
+	}
+	ev, ok := v.(Event)
+	if !ok {
+		if p, isPtr := v.(*Event); isPtr && p != nil {
+			ev = *p
+		} else {
+			ev = Event{Data: v}
+		}
+	}
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		buf.WriteString("id: " + oneLine(ev.ID) + "\n")
+	}
+	if ev.Event != "" {
+		buf.WriteString("event: " + oneLine(ev.Event) + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(int64(ev.Retry/time.Millisecond), 10) + "\n")
+	}
+	if ev.Data != nil {
+		var data []byte
+		if str, isString := ev.Data.(string); isString {
+			data = []byte(str)
+		} else {
+			var e error
+			if data, e = s.marshal(ev.Data); e != nil {
+				return e
+			}
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+func (s *sseWriter) heartbeat() error {
+	return s.write([]byte(": ping\n\n"))
+}
+
+func (s *sseWriter) write(b []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, e := s.w.Write(b); e != nil {
+		return e
+	}
+	s.w.Flush()
+	return nil
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// bindEventStream starts the stream and its heartbeats, stop must be called when the method returns
+func (a *Api) bindEventStream(c *Console) (es reflect.Value, stop func()) {
+	s := newSseWriter(c)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	if interval := a.Service().Quark().option.SSEHeartbeat; interval > 0 {
+		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-c.r.Context().Done():
+					return
+				case <-ticker.C:
+					if s.heartbeat() != nil {
+						return
+					}
+				}
+			}
+		}()
+	} else {
+		close(stopped)
+	}
+	return reflect.ValueOf(EventStream{s}), func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func wantsEventStream(r *http.Request) bool {
+	for _, s := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt := strings.TrimSpace(strings.Split(s, ";")[0]); mt == MediaTypeEventStream {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEvents sends each item of the channel as an event, with heartbeats between them,
+// it stops when the channel is closed or the client goes away
+func (a *Api) writeEvents(c *Console, ch reflect.Value) {
+	s := newSseWriter(c)
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.r.Context().Done())},
+	}
+	if interval := a.Service().Quark().option.SSEHeartbeat; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ticker.C)})
+	}
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		switch {
+		case chosen == 1 || chosen == 0 && !ok:
+			return
+		case chosen == 2:
+			if s.heartbeat() != nil {
+				return
+			}
+		default:
+			if s.send(item.Interface()) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package quark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseService struct {
+	Console
+}
+
+func (s sseService) Events() <-chan Event {
+	ch := make(chan Event, 2)
+	ch <- Event{ID: s.StreamLastEventID() + "1", Event: "greet", Data: Greeting{"a", 1}}
+	ch <- Event{ID: "2", Data: "multi\nline"}
+	close(ch)
+	return ch
+}
+
+func (s sseService) Slow() <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		time.Sleep(30 * time.Millisecond)
+		ch <- 1
+	}()
+	return ch
+}
+
+func (s sseService) Watch(es EventStream) {
+	for i := 0; ; i++ {
+		select {
+		case <-es.Done():
+			return
+		default:
+		}
+		if es.Send(i) != nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerSentEvents(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(sseService{})
+	Expect := func(path, lastEventId string, rsp string) string {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept", MediaTypeEventStream)
+		r.Header.Set("Last-Event-ID", lastEventId)
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != MediaTypeEventStream {
+			t.Errorf("%s expects Content-Type %s but actual %s", path, MediaTypeEventStream, ct)
+		}
+		if rsp != "" && w.Body.String() != rsp {
+			t.Errorf("%s expects %q but actual %q", path, rsp, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	Expect("/sseService/events", "7", "id: 71\nevent: greet\ndata: {\"name\":\"a\",\"times\":1}\n\nid: 2\ndata: multi\ndata: line\n\n")
+	q.WithSSEHeartbeat(10 * time.Millisecond)
+	if rsp := Expect("/sseService/slow", "", ""); !strings.HasPrefix(rsp, ": ping\n\n") || !strings.HasSuffix(rsp, ": ping\n\ndata: 1\n\n") {
+		t.Errorf("expects heartbeats before the event but actual %q", rsp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/sseService/watch", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		q.ServeHTTP(w, r)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event stream should stop after client disconnects")
+	}
+	if !strings.HasPrefix(w.Body.String(), "data: 0\n\ndata: 1\n\n") {
+		t.Errorf("unexpected events %q", w.Body.String())
+	}
+}
@@ -0,0 +1,117 @@
+package quark
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+)
+
+const (
+	MediaTypeOctetStream = "application/octet-stream"
+	MediaTypeNdjson      = "application/x-ndjson"
+)
+
+// streamKind tells how a response is written without full marshalling
+type streamKind int
+
+const (
+	streamNone     streamKind = iota
+	streamWriterTo            // io.WriterTo writes itself
+	streamReader              // io.Reader is copied
+	streamChan                // items of a channel are written as NDJSON lines or server-sent events
+)
+
+var (
+	writerToType = reflect.TypeOf((*io.WriterTo)(nil)).Elem()
+)
+
+func streamKindOf(t reflect.Type) streamKind {
+	switch {
+	case t.Implements(writerToType):
+		return streamWriterTo
+	case t.Implements(readerType):
+		return streamReader
+	case t.Kind() == reflect.Chan && t.ChanDir()&reflect.RecvDir != 0:
+		return streamChan
+	}
+	return streamNone
+}
+
+// writeStream writes a streamed response, a Content-Type set by the handler is kept
+func (a *Api) writeStream(c *Console, v reflect.Value) {
+	w := c.w
+	if v.Kind() != reflect.Chan && isNil(v) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch a.stream {
+	case streamWriterTo, streamReader:
+		if closer, ok := v.Interface().(io.Closer); ok {
+			defer closer.Close()
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", MediaTypeOctetStream)
+		}
+		w.WriteHeader(http.StatusOK)
+		if a.stream == streamWriterTo {
+			v.Interface().(io.WriterTo).WriteTo(w)
+		} else {
+			io.Copy(w, v.Interface().(io.Reader))
+		}
+	case streamChan:
+		if wantsEventStream(c.r) {
+			a.writeEvents(c, v)
+		} else {
+			a.writeNdjson(c, v)
+		}
+	}
+}
+
+// isNil tells whether v is nil, values of kinds which can't be nil, like a struct implementing io.Reader, aren't
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// writeNdjson marshals each item by Quark.Marshal into one line and flushes it, it stops when the
+// channel is closed or the client goes away
+func (a *Api) writeNdjson(c *Console, ch reflect.Value) {
+	w := c.w
+	if v := ch; v.IsNil() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", MediaTypeNdjson)
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.r.Context().Done())},
+	}
+	marshal := a.Service().Quark().Marshal
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 || !ok {
+			return
+		}
+		b, e := marshal(item.Interface())
+		if e != nil {
+			return
+		}
+		if _, e = w.Write(append(b, '\n')); e != nil {
+			return
+		}
+		w.Flush()
+	}
+}
+
+// maxBodySize returns the body limit of the api, 0 means unlimited
+func (a *Api) maxBodySize() int64 {
+	if a.MaxBodySize > 0 {
+		return a.MaxBodySize
+	}
+	return a.Service().Quark().option.MaxBodySize
+}
@@ -0,0 +1,88 @@
+package quark
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type streamService struct {
+	Console
+}
+
+func (s streamService) ConfigureApi(api *Api) {
+	if api.Name() == "POST_Ingest_name" {
+		api.MaxBodySize = 8
+	}
+}
+
+func (s streamService) POST_Ingest_name(name string, body io.Reader) (n int, e error) {
+	b, e := ioutil.ReadAll(body)
+	return len(b), e
+}
+
+func (s streamService) Export_Reader() io.Reader {
+	return strings.NewReader("reader")
+}
+
+func (s streamService) Export_Buffer() *bytes.Buffer {
+	s.ResponseWriter().Header().Set("Content-Type", "text/csv")
+	return bytes.NewBufferString("a,b\n1,2\n")
+}
+
+// letters is an io.Reader by value, so the result of Export_Letters can't be nil
+type letters struct {
+	*strings.Reader
+}
+
+func (s streamService) Export_Letters() letters {
+	return letters{strings.NewReader("abc")}
+}
+
+func (s streamService) Export_Items() <-chan Greeting {
+	ch := make(chan Greeting)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 3; i++ {
+			ch <- Greeting{"item", i}
+		}
+	}()
+	return ch
+}
+
+func TestStream(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(streamService{})
+	Expect := func(method, path, body string, status int, contentType, rsp string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if w.Code != status {
+			t.Errorf("%s expects status %d but actual %d", path, status, w.Code)
+			return
+		}
+		if status != http.StatusOK {
+			return
+		}
+		if actual := w.Header().Get("Content-Type"); actual != contentType {
+			t.Errorf("%s expects Content-Type %s but actual %s", path, contentType, actual)
+		}
+		if w.Body.String() != rsp {
+			t.Errorf("%s expects body %q but actual %q", path, rsp, w.Body.String())
+		}
+	}
+	Expect(http.MethodPost, "/streamService/ingest/x", "12345678", http.StatusOK, MediaTypeJson, "8")
+	Expect(http.MethodPost, "/streamService/ingest/x", "123456789", http.StatusRequestEntityTooLarge, "", "")
+	Expect(http.MethodGet, "/streamService/export/reader", "", http.StatusOK, MediaTypeOctetStream, "reader")
+	Expect(http.MethodGet, "/streamService/export/buffer", "", http.StatusOK, "text/csv", "a,b\n1,2\n")
+	Expect(http.MethodGet, "/streamService/export/letters", "", http.StatusOK, MediaTypeOctetStream, "abc")
+	Expect(http.MethodGet, "/streamService/export/items", "", http.StatusOK, MediaTypeNdjson,
+		`{"name":"item","times":0}`+"\n"+`{"name":"item","times":1}`+"\n"+`{"name":"item","times":2}`+"\n")
+
+	q.WithMaxBodySize(4)
+	q.RegisterService(validateService{})
+	Expect(http.MethodPost, "/validateService/sign_up", `{"Name":"bob"}`, http.StatusRequestEntityTooLarge, "", "")
+}
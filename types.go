@@ -1,12 +1,18 @@
 package quark
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
+	"time"
 )
 
 type String string
 type Int int
 type Number float64
+type Bool bool
+type Time time.Time         // RFC3339 in url
+type Duration time.Duration // time.ParseDuration format in url, e.g. 1h30m
 
 func (s String) V() string {
 	return string(s)
@@ -20,16 +26,181 @@ func (n Number) V() float64 {
 	return float64(n)
 }
 
+func (b Bool) V() bool {
+	return bool(b)
+}
+
+func (t Time) V() time.Time {
+	return time.Time(t)
+}
+
+func (t Time) String() string {
+	return time.Time(t).Format(time.RFC3339Nano)
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	return time.Time(t).MarshalJSON()
+}
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	return (*time.Time)(t).UnmarshalJSON(b)
+}
+
+func (d Duration) V() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+var (
+	StringType          = reflect.TypeOf(String(""))
+	IntType             = reflect.TypeOf(Int(0))
+	NumberType          = reflect.TypeOf(Number(0))
+	BoolType            = reflect.TypeOf(Bool(false))
+	TimeType            = reflect.TypeOf(Time{})
+	DurationType        = reflect.TypeOf(Duration(0))
+	StringPointerType   = reflect.PointerTo(StringType)
+	IntPointerType      = reflect.PointerTo(IntType)
+	NumberPointerType   = reflect.PointerTo(NumberType)
+	BoolPointerType     = reflect.PointerTo(BoolType)
+	TimePointerType     = reflect.PointerTo(TimeType)
+	DurationPointerType = reflect.PointerTo(DurationType)
+	StringSliceType     = reflect.SliceOf(StringType)
+	IntSliceType        = reflect.SliceOf(IntType)
+	NumberSliceType     = reflect.SliceOf(NumberType)
+	BoolSliceType       = reflect.SliceOf(BoolType)
+	TimeSliceType       = reflect.SliceOf(TimeType)
+	DurationSliceType   = reflect.SliceOf(DurationType)
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// urlParser parses one url value into the element type
+type urlParser func(s string, v reflect.Value) error
+
 var (
-	StringType        = reflect.TypeOf(String(""))
-	IntType           = reflect.TypeOf(Int(0))
-	NumberType        = reflect.TypeOf(Number(0))
-	StringPointerType = reflect.PointerTo(StringType)
-	IntPointerType    = reflect.PointerTo(IntType)
-	NumberPointerType = reflect.PointerTo(NumberType)
+	urlParsers = map[reflect.Type]urlParser{
+		StringType: func(s string, v reflect.Value) error {
+			v.SetString(s)
+			return nil
+		},
+		IntType: func(s string, v reflect.Value) error {
+			i, e := strconv.ParseInt(s, 10, 64)
+			if e != nil {
+				return fmt.Errorf("as int")
+			}
+			v.SetInt(i)
+			return nil
+		},
+		NumberType: func(s string, v reflect.Value) error {
+			n, e := strconv.ParseFloat(s, 64)
+			if e != nil {
+				return fmt.Errorf("as number")
+			}
+			v.SetFloat(n)
+			return nil
+		},
+		BoolType: func(s string, v reflect.Value) error {
+			b, e := strconv.ParseBool(s)
+			if e != nil {
+				return fmt.Errorf("as bool")
+			}
+			v.SetBool(b)
+			return nil
+		},
+		TimeType: func(s string, v reflect.Value) error {
+			t, e := time.Parse(time.RFC3339Nano, s)
+			if e != nil {
+				return fmt.Errorf("as RFC3339 time")
+			}
+			v.Set(reflect.ValueOf(Time(t)))
+			return nil
+		},
+		DurationType: func(s string, v reflect.Value) error {
+			d, e := time.ParseDuration(s)
+			if e != nil {
+				return fmt.Errorf("as duration")
+			}
+			v.SetInt(int64(d))
+			return nil
+		},
+	}
+	urlTypeFormats = map[reflect.Type]TypeAndFormat{
+		StringType:   {"string", ""},
+		IntType:      {"integer", "int64"},
+		NumberType:   {"number", "double"},
+		BoolType:     {"boolean", ""},
+		TimeType:     {"string", "date-time"},
+		DurationType: {"string", "duration"},
+	}
 )
 
+// urlElemType returns the element type of url types, e.g. Int for *Int or []Int
+func urlElemType(t reflect.Type) (elem reflect.Type, repeated bool) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return t.Elem(), false
+	case reflect.Slice:
+		return t.Elem(), true
+	}
+	return t, false
+}
+
 func IsUrlType(t reflect.Type) bool {
-	return t == StringType || t == IntType || t == NumberType ||
-		t == StringPointerType || t == IntPointerType || t == NumberPointerType
+	elem, _ := urlElemType(t)
+	_, ok := urlParsers[elem]
+	return ok
+}
+
+// SetUrlValue sets url values vs into v whose type must be an url type, blank values reset v to zero
+func SetUrlValue(v reflect.Value, vs []string) error {
+	return newUrlSetter(v.Type())(v, vs)
+}
+
+// urlSetter is SetUrlValue for a type known in advance
+type urlSetter func(v reflect.Value, vs []string) error
+
+// newUrlSetter resolves the parser of url type t once, so setting values doesn't look it up again
+func newUrlSetter(t reflect.Type) urlSetter {
+	elem, _ := urlElemType(t)
+	parse := urlParsers[elem]
+	zero := reflect.Zero(t)
+	switch t.Kind() {
+	case reflect.Ptr:
+		return func(v reflect.Value, vs []string) error {
+			if blankValues(vs) {
+				v.Set(zero)
+				return nil
+			}
+			pv := reflect.New(elem)
+			if e := parse(vs[0], pv.Elem()); e != nil {
+				return e
+			}
+			v.Set(pv)
+			return nil
+		}
+	case reflect.Slice:
+		return func(v reflect.Value, vs []string) error {
+			if blankValues(vs) {
+				v.Set(zero)
+				return nil
+			}
+			sv := reflect.MakeSlice(t, len(vs), len(vs))
+			for i := range vs {
+				if e := parse(vs[i], sv.Index(i)); e != nil {
+					return e
+				}
+			}
+			v.Set(sv)
+			return nil
+		}
+	}
+	return func(v reflect.Value, vs []string) error {
+		if blankValues(vs) {
+			v.Set(zero)
+			return nil
+		}
+		return parse(vs[0], v)
+	}
 }
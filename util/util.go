@@ -23,6 +23,32 @@ func PascalSplit(s string) (ss []string) {
 	return ss
 }
 
+// SplitWords splits an identifier into words, acronyms stay in one word, e.g.
+// HTTPProxy -> HTTP Proxy, GetVINStatus -> Get VIN Status, userID -> user ID, '_' and '-' are separators
+func SplitWords(s string) (words []string) {
+	rs := []rune(s)
+	start := 0
+	for i := 0; i <= len(rs); i++ {
+		if i == len(rs) || rs[i] == '_' || rs[i] == '-' {
+			if i > start {
+				words = append(words, string(rs[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(rs[i]) {
+			continue
+		}
+		prev := rs[i-1]
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+			unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1]) {
+			words = append(words, string(rs[start:i]))
+			start = i
+		}
+	}
+	return
+}
+
 func PascalToSnake(s string) string {
 	b := strings.Builder{}
 	for i, r := range s {
@@ -64,12 +90,21 @@ type PathVar struct {
 	{f} - number
 */
 func FuncNameToPathWithVars(s string, varsType []reflect.Kind) (pathFormat string, vars []PathVar, e error) {
+	return FuncNameToPathWithNaming(s, varsType, PascalToSnake)
+}
+
+// FuncNameToPathWithNaming is FuncNameToPathWithVars which names path components and variables by naming
+func FuncNameToPathWithNaming(s string, varsType []reflect.Kind, naming func(string) string) (pathFormat string, vars []PathVar, e error) {
 	ss := strings.Split(s, "_")
 	vti := 0
 	for i := range ss {
 		rs := []rune(ss[i])
+		if len(rs) == 0 {
+			e = fmt.Errorf("parsing path elements: empty element at %d of %s", i, s)
+			return
+		}
 		if unicode.IsUpper(rs[0]) {
-			pathFormat += "/" + PascalToSnake(ss[i])
+			pathFormat += "/" + naming(ss[i])
 		} else {
 			if vti >= len(varsType) {
 				e = fmt.Errorf("parsing path variables: name variable num > in parameter num")
@@ -88,7 +123,7 @@ func FuncNameToPathWithVars(s string, varsType []reflect.Kind) (pathFormat strin
 				return
 			}
 			pathFormat += "/{" + typeChar + "}"
-			vars = append(vars, PathVar{i, typeChar + "." + CamelToSnake(ss[i])})
+			vars = append(vars, PathVar{i, typeChar + "." + naming(ss[i])})
 			vti += 1
 		}
 	}
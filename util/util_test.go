@@ -34,3 +34,18 @@ func TestPascalSplit(t *testing.T) {
 	Expect("ABCDEF", "a_b_c_d_e_f")
 	Expect("HelloWorld", "hello_world")
 }
+
+func TestSplitWords(t *testing.T) {
+	Expect := func(src, dst string) {
+		if actual := SplitWords(src); strings.Join(actual, " ") != dst {
+			t.Errorf("SplitWords(\"%s\") expects \"%s\" but actual \"%s\"", src, dst, actual)
+		}
+	}
+	Expect("ABCDEF", "ABCDEF")
+	Expect("HelloWorld", "Hello World")
+	Expect("HTTPProxy", "HTTP Proxy")
+	Expect("GetVINStatus", "Get VIN Status")
+	Expect("userID", "user ID")
+	Expect("Vehicle2Go", "Vehicle2 Go")
+	Expect("snake_case-kebab", "snake case kebab")
+}
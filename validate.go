@@ -0,0 +1,362 @@
+package quark
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-openapi/spec"
+)
+
+// FieldRules are parsed from quark tag, the leading name may be blank, e.g.
+// `quark:"name,required,min=1,max=64,pattern=^[a-z]+$,enum=a|b"`.
+// min/max limit the value of numbers, the length of strings and the item count of slices,
+// rules other than required are skipped when the value is absent: url values not in the request,
+// nil pointers, or zero values of other body fields
+type FieldRules struct {
+	Required bool
+	Min      *float64
+	Max      *float64
+	Pattern  *regexp.Regexp
+	Enum     []string
+}
+
+func (fr FieldRules) Empty() bool {
+	return !fr.Required && fr.Min == nil && fr.Max == nil && fr.Pattern == nil && len(fr.Enum) == 0
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every failing field of a request
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	ss := make([]string, len(ve))
+	for i := range ve {
+		ss[i] = ve[i].Field + ": " + ve[i].Message
+	}
+	return "validation failed, " + strings.Join(ss, "; ")
+}
+
+func (ve ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+var (
+	tagOptionKeys = map[string]bool{
+		"required": true,
+		"min":      true,
+		"max":      true,
+		"pattern":  true,
+		"enum":     true,
+		"inject":   true,
+		"in":       true,
+	}
+)
+
+// QuarkTagOptions returns the options after the name in quark tag, in order
+func QuarkTagOptions(f reflect.StructField) (options [][2]string) {
+	tag := strings.Trim(f.Tag.Get("quark"), cutset)
+	if tag == "" {
+		return nil
+	}
+	ss := strings.Split(tag, ",")
+	for _, s := range ss[1:] {
+		kv := strings.SplitN(s, "=", 2)
+		k := strings.Trim(kv[0], cutset)
+		if !tagOptionKeys[k] && len(options) > 0 && options[len(options)-1][0] == "pattern" {
+			// comma inside pattern
+			options[len(options)-1][1] += "," + s
+			continue
+		}
+		var v string
+		if len(kv) > 1 {
+			v = kv[1]
+		}
+		options = append(options, [2]string{k, v})
+	}
+	return
+}
+
+func ParseFieldRules(f reflect.StructField) (fr FieldRules, e error) {
+	for _, kv := range QuarkTagOptions(f) {
+		switch kv[0] {
+		case "required":
+			fr.Required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				e = fmt.Errorf("field %s has invalid %s value [%s]", f.Name, kv[0], kv[1])
+				return
+			}
+			if kv[0] == "min" {
+				fr.Min = &n
+			} else {
+				fr.Max = &n
+			}
+		case "pattern":
+			if fr.Pattern, e = regexp.Compile(kv[1]); e != nil {
+				e = fmt.Errorf("field %s has invalid pattern [%s], %v", f.Name, kv[1], e)
+				return
+			}
+		case "enum":
+			fr.Enum = strings.Split(kv[1], "|")
+		}
+	}
+	return
+}
+
+type fieldValidator struct {
+	index []int
+	name  string
+	rules FieldRules
+	bound bool // a url value or file of the request, the binding tells whether it's supplied
+}
+
+var (
+	validatorCache sync.Map // reflect.Type -> []fieldValidator, of Validate
+)
+
+// structValidators compiles the rules of t and its nested structs, url fields are named by naming;
+// apis keep their own validators since naming differs between Quarks
+func structValidators(t reflect.Type, naming PathNaming) ([]fieldValidator, error) {
+	return buildValidators(t, nil, "", 0, naming)
+}
+
+func buildValidators(t reflect.Type, index []int, prefix string, depth int, naming PathNaming) (vs []fieldValidator, e error) {
+	if depth > 8 {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fi := append(append([]int{}, index...), i)
+		name := prefix + validationFieldName(f, naming)
+		fr, err := ParseFieldRules(f)
+		if err != nil {
+			return nil, err
+		}
+		if !fr.Empty() {
+			vs = append(vs, fieldValidator{index: fi, name: name, rules: fr})
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Struct && !IsUrlType(ft) && ft != timeType && ft != FileType {
+			sub, err := buildValidators(ft, fi, name+".", depth+1, naming)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, sub...)
+		}
+	}
+	return
+}
+
+func validationFieldName(f reflect.StructField, naming PathNaming) string {
+	if IsUrlType(f.Type) {
+		return naming.field(f)
+	}
+	if tag := strings.Trim(f.Tag.Get("json"), cutset); tag != "" && tag != "-" {
+		if n := strings.Split(tag, ",")[0]; n != "" {
+			return n
+		}
+	}
+	return f.Name
+}
+
+// Validate checks v, a struct value, against the rules in its quark tags
+func Validate(v reflect.Value) error {
+	if vs, ok := validatorCache.Load(v.Type()); ok {
+		return validate(v, vs.([]fieldValidator), nil)
+	}
+	vs, e := structValidators(v.Type(), nil)
+	if e != nil {
+		return e
+	}
+	validatorCache.Store(v.Type(), vs)
+	return validate(v, vs, nil)
+}
+
+// validate checks v by vs, supplied tells by field index whether bound fields are in the request,
+// nil supplied takes zero values as absent
+func validate(v reflect.Value, vs []fieldValidator, supplied []bool) error {
+	var errs ValidationError
+	for i := range vs {
+		if fe := vs[i].check(v, supplied); fe != nil {
+			errs = append(errs, *fe)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check applies the rules to a supplied value, including zero, and only required to an absent one;
+// bound fields are supplied when the request has them, pointers when they are not nil,
+// and other fields, whose absence can't be told, when they are not zero
+func (fv *fieldValidator) check(root reflect.Value, supplied []bool) *FieldError {
+	r := fv.rules
+	known := fv.bound && supplied != nil
+	if known && !supplied[fv.index[0]] {
+		if r.Required {
+			return fv.fail("required", "is required")
+		}
+		return nil
+	}
+	v := root
+	for _, i := range fv.index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil // parent is absent, rules of children don't apply
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if r.Required {
+				return fv.fail("required", "is required")
+			}
+			return nil
+		}
+		v, known = v.Elem(), true
+	}
+	if !known && v.IsZero() {
+		if r.Required {
+			return fv.fail("required", "is required")
+		}
+		return nil
+	}
+	var measure float64
+	var measured bool
+	var unit string
+	switch kind := v.Kind(); {
+	case reflect.Int <= kind && kind <= reflect.Int64:
+		measure, measured = float64(v.Int()), true
+	case reflect.Uint <= kind && kind <= reflect.Uintptr:
+		measure, measured = float64(v.Uint()), true
+	case reflect.Float32 == kind || reflect.Float64 == kind:
+		measure, measured = v.Float(), true
+	case reflect.String == kind:
+		measure, measured, unit = float64(utf8.RuneCountInString(v.String())), true, " characters"
+	case reflect.Slice == kind || reflect.Array == kind || reflect.Map == kind:
+		measure, measured, unit = float64(v.Len()), true, " items"
+	}
+	if measured {
+		if r.Min != nil && measure < *r.Min {
+			return fv.fail("min", fmt.Sprintf("must be at least %v%s", *r.Min, unit))
+		}
+		if r.Max != nil && measure > *r.Max {
+			return fv.fail("max", fmt.Sprintf("must be at most %v%s", *r.Max, unit))
+		}
+	}
+	if r.Pattern != nil && v.Kind() == reflect.String && !r.Pattern.MatchString(v.String()) {
+		return fv.fail("pattern", fmt.Sprintf("must match %s", r.Pattern.String()))
+	}
+	if len(r.Enum) > 0 {
+		s := fmt.Sprint(v.Interface())
+		found := false
+		for _, e := range r.Enum {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fv.fail("enum", fmt.Sprintf("must be one of %s", strings.Join(r.Enum, ", ")))
+		}
+	}
+	return nil
+}
+
+func (fv *fieldValidator) fail(rule, msg string) *FieldError {
+	return &FieldError{
+		Field:   fv.name,
+		Rule:    rule,
+		Message: msg,
+	}
+}
+
+// enumValues converts enum strings into values of the field's json type
+func (fr FieldRules) enumValues(t reflect.Type) (values []interface{}) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, s := range fr.Enum {
+		var v interface{} = s
+		kind := t.Kind()
+		switch {
+		case reflect.Int <= kind && kind <= reflect.Uint64:
+			if i, e := strconv.ParseInt(s, 10, 64); e == nil {
+				v = i
+			}
+		case reflect.Float32 <= kind && kind <= reflect.Float64:
+			if f, e := strconv.ParseFloat(s, 64); e == nil {
+				v = f
+			}
+		}
+		values = append(values, v)
+	}
+	return
+}
+
+func limitInt(f *float64) *int64 {
+	if f == nil {
+		return nil
+	}
+	i := int64(*f)
+	return &i
+}
+
+// applySchema reflects the rules of a field into its property schema
+func (fr FieldRules) applySchema(schema *spec.Schema, t reflect.Type) {
+	if len(schema.Type) > 0 {
+		switch schema.Type[0] {
+		case "integer", "number":
+			schema.Minimum, schema.Maximum = fr.Min, fr.Max
+		case "string":
+			schema.MinLength, schema.MaxLength = limitInt(fr.Min), limitInt(fr.Max)
+		case "array":
+			schema.MinItems, schema.MaxItems = limitInt(fr.Min), limitInt(fr.Max)
+		}
+	}
+	if fr.Pattern != nil {
+		schema.Pattern = fr.Pattern.String()
+	}
+	if len(fr.Enum) > 0 {
+		schema.Enum = fr.enumValues(t)
+	}
+}
+
+// applyParameter reflects the rules of a url field into its parameter
+func (fr FieldRules) applyParameter(param *spec.Parameter, t reflect.Type) {
+	switch param.Type {
+	case "integer", "number":
+		param.Minimum, param.Maximum = fr.Min, fr.Max
+	case "string":
+		param.MinLength, param.MaxLength = limitInt(fr.Min), limitInt(fr.Max)
+	case "array":
+		param.MinItems, param.MaxItems = limitInt(fr.Min), limitInt(fr.Max)
+	}
+	if fr.Pattern != nil {
+		param.Pattern = fr.Pattern.String()
+	}
+	if len(fr.Enum) > 0 {
+		param.Enum = fr.enumValues(t)
+	}
+	if fr.Required {
+		param.Required = true
+	}
+}
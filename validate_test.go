@@ -0,0 +1,173 @@
+package quark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type validateService struct {
+	Console
+}
+
+type SignUp struct {
+	Name    string   `quark:"name,required,min=1,max=8,pattern=^[a-z]+$"`
+	Role    string   `quark:",enum=admin|user"`
+	Age     int      `json:"age" quark:",min=18,max=150"`
+	Tags    []string `quark:",max=2"`
+	Ref     String   `quark:"ref,pattern=^[a-z]{2,3}$"`
+	Profile struct {
+		Email string `quark:",required"`
+	}
+}
+
+func (s validateService) POST_SignUp(req SignUp) string {
+	return req.Name
+}
+
+func TestValidate(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(validateService{})
+	Expect := func(path, body string, status int, fields ...string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if w.Code != status {
+			t.Errorf("%s %s expects status %d but actual %d, %s", path, body, status, w.Code, w.Body.String())
+			return
+		}
+		if status != http.StatusBadRequest {
+			return
+		}
+		if ct := w.Header().Get("Content-Type"); ct != MediaTypeProblemJson {
+			t.Errorf("expects Content-Type %s but actual %s", MediaTypeProblemJson, ct)
+		}
+		var rsp struct {
+			Status int             `json:"status"`
+			Errors ValidationError `json:"errors"`
+		}
+		if e := json.Unmarshal(w.Body.Bytes(), &rsp); e != nil || rsp.Status != status {
+			t.Fatal(e, w.Body.String())
+		}
+		var actual []string
+		for _, fe := range rsp.Errors {
+			actual = append(actual, fe.Field+":"+fe.Rule)
+		}
+		if strings.Join(actual, ",") != strings.Join(fields, ",") {
+			t.Errorf("%s expects failing fields %v but actual %v", body, fields, actual)
+		}
+	}
+	Expect("/validateService/sign_up", `{"Name":"bob","Role":"user","age":20,"Profile":{"Email":"a@b"}}`, http.StatusOK)
+	Expect("/validateService/sign_up?ref=abc", `{"Name":"bob","age":20,"Profile":{"Email":"a@b"}}`, http.StatusOK)
+	Expect("/validateService/sign_up?ref=a1", `{"Name":"Bob1","Role":"guest","age":3,"Tags":["a","b","c"]}`, http.StatusBadRequest,
+		"Name:pattern", "Role:enum", "age:min", "Tags:max", "ref:pattern", "Profile.Email:required")
+	Expect("/validateService/sign_up", `{"age":20,"Profile":{"Email":"a@b"}}`, http.StatusBadRequest, "Name:required")
+}
+
+func TestValidateSchema(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(validateService{})
+	q.SwaggerSpec()
+	schema := q.SwaggerSchemaFromType(reflect.TypeOf(SignUp{}), true)
+	if !reflect.DeepEqual(schema.Required, []string{"Name"}) {
+		t.Errorf("expects required [Name] but actual %v", schema.Required)
+	}
+	name := schema.Properties["Name"]
+	if *name.MinLength != 1 || *name.MaxLength != 8 || name.Pattern != "^[a-z]+$" {
+		t.Errorf("unexpected Name schema %s", Js(name))
+	}
+	if age := schema.Properties["Age"]; *age.Minimum != 18 || *age.Maximum != 150 {
+		t.Errorf("unexpected Age schema %s", Js(age))
+	}
+	if role := schema.Properties["Role"]; len(role.Enum) != 2 {
+		t.Errorf("unexpected Role schema %s", Js(role))
+	}
+	if tags := schema.Properties["Tags"]; *tags.MaxItems != 2 {
+		t.Errorf("unexpected Tags schema %s", Js(tags))
+	}
+	op := q.Services[0].Apis[0].SwaggerOperations()
+	for _, p := range op.Parameters {
+		if p.In == "query" && (p.Name != "ref" || p.Pattern != "^[a-z]{2,3}$") {
+			t.Errorf("unexpected query parameter %s", Js(p))
+		}
+	}
+}
+
+type plateService struct {
+	Console
+}
+
+func (s plateService) Plate(req struct {
+	PlateNo String `quark:",required"`
+}) string {
+	return string(req.PlateNo)
+}
+
+func prefixNaming(prefix string) PathNaming {
+	return func(name string) string {
+		return prefix + strings.ToLower(name)
+	}
+}
+
+func TestValidateNaming(t *testing.T) {
+	for _, prefix := range []string{"a", "b"} {
+		q := NewQuark()
+		q.WithPathNaming(prefixNaming(prefix))
+		q.RegisterService(plateService{})
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+prefix+"plateservice/"+prefix+"plate", nil))
+		var rsp struct {
+			Errors ValidationError `json:"errors"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &rsp)
+		if w.Code != http.StatusBadRequest || len(rsp.Errors) != 1 || rsp.Errors[0].Field != prefix+"plateno" {
+			t.Errorf("%s expects %splateno required but actual %d %s", prefix, prefix, w.Code, w.Body.String())
+		}
+	}
+}
+
+type MemberFilter struct {
+	Active Bool `quark:"active,required"`
+	Age    Int  `quark:"age,min=18"`
+	Score  *int `json:"score" quark:",min=1"`
+}
+
+type memberService struct {
+	Console
+}
+
+func (s memberService) POST_Members(req MemberFilter) string {
+	return "ok"
+}
+
+func TestValidateZero(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(memberService{})
+	for query, expect := range map[string]string{
+		"?active=false":       "200",
+		"?active=true&age=18": "200",
+		"":                    "active:required",
+		"?active=":            "active:required",
+		"?active=true&age=0":  "age:min",
+	} {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/memberService/members"+query, nil))
+		if actual := strconv.Itoa(w.Code); actual != expect {
+			var rsp struct {
+				Errors ValidationError `json:"errors"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &rsp)
+			if len(rsp.Errors) != 1 || rsp.Errors[0].Field+":"+rsp.Errors[0].Rule != expect {
+				t.Errorf("%s expects %s but actual %s %s", query, expect, actual, w.Body.String())
+			}
+		}
+	}
+	w := httptest.NewRecorder()
+	q.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/memberService/members?active=true", strings.NewReader(`{"score":0}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"rule":"min"`) {
+		t.Errorf("expects a supplied zero pointer checked by min but actual %d %s", w.Code, w.Body.String())
+	}
+}
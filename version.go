@@ -0,0 +1,100 @@
+package quark
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/spec"
+)
+
+// Deprecation marks the apis of an old version, they answer with Deprecation, Sunset and Link headers
+// and are documented as deprecated
+type Deprecation struct {
+	Since  time.Time // when the apis were deprecated, zero writes Deprecation: true
+	Sunset time.Time // when the apis go away, zero writes no Sunset
+	Link   string    // document of the deprecation or the migration, written as Link with rel="deprecation"
+}
+
+func (d *Deprecation) writeHeaders(h http.Header) {
+	if d.Since.IsZero() {
+		h.Set("Deprecation", "true")
+	} else {
+		h.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	}
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		h.Add("Link", "<"+d.Link+`>; rel="deprecation"`)
+	}
+}
+
+// Version returns a group at prefix version whose services are documented as that version,
+// e.g. q.Version("v1").RegisterService(Vehicle{}) and q.Version("v2").RegisterService(VehicleV2{})
+func (q *Quark) Version(version string, mws ...Middleware) *Group {
+	g := q.Group(version, mws...)
+	g.version = g.prefix
+	return g
+}
+
+// Deprecate marks the services registered into g afterwards as deprecated, Api.Deprecation may be changed by ConfigureApi
+func (g *Group) Deprecate(d Deprecation) *Group {
+	g.deprecation = &d
+	return g
+}
+
+// Versions returns the versions of registered services in order
+func (q *Quark) Versions() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var versions []string
+	seen := make(map[string]bool)
+	for i := range q.Services {
+		if v := q.Services[i].Version; v != "" && !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// SwaggerSpecOf returns the document of the services of version, whose paths are relative to the version prefix
+func (q *Quark) SwaggerSpecOf(version string) *spec.Swagger {
+	all := q.SwaggerSpec()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if doc, ok := q.versionSwaggers[version]; ok {
+		return doc
+	}
+	doc := new(spec.Swagger)
+	doc.Swagger = all.Swagger
+	info := *all.Info
+	info.Version = version
+	doc.Info = &info
+	doc.Definitions = all.Definitions
+	doc.BasePath = all.BasePath + "/" + version
+	doc.Paths = &spec.Paths{Paths: make(map[string]spec.PathItem)}
+	prefix := "/" + version
+	for i := range q.Services {
+		s := &q.Services[i]
+		if s.Version != version {
+			continue
+		}
+		doc.Tags = append(doc.Tags, spec.NewTag(s.Name, s.ServiceType.Name(), nil))
+		for j := range s.Apis {
+			path := s.mountPath() + s.Apis[j].docPath
+			if item, ok := all.Paths.Paths[path]; ok {
+				doc.Paths.Paths[strings.TrimPrefix(path, prefix)] = item
+			}
+		}
+	}
+	if q.versionSwaggers == nil {
+		q.versionSwaggers = make(map[string]*spec.Swagger)
+	}
+	q.versionSwaggers[version] = doc
+	return doc
+}
@@ -0,0 +1,66 @@
+package quark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type vehicleService struct {
+	Console
+}
+
+func (s vehicleService) Status_vin(vin string) string {
+	return "v1 " + vin
+}
+
+type vehicleServiceV2 struct {
+	Console
+}
+
+func (s vehicleServiceV2) ServiceName() string {
+	return "vehicleService"
+}
+
+func (s vehicleServiceV2) Status_vin(vin string) string {
+	return "v2 " + vin
+}
+
+func TestVersions(t *testing.T) {
+	q := NewQuark()
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	q.Version("v1").Deprecate(Deprecation{
+		Since:  since,
+		Sunset: since.AddDate(1, 0, 0),
+		Link:   "https://example.com/migrate",
+	}).RegisterService(vehicleService{})
+	q.Version("v2").RegisterService(vehicleServiceV2{})
+
+	Expect := func(path string, rsp string, deprecation, sunset string) {
+		w := httptest.NewRecorder()
+		q.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != rsp {
+			t.Errorf("%s expects %s but actual %d %s", path, rsp, w.Code, w.Body.String())
+		}
+		if h := w.Header(); h.Get("Deprecation") != deprecation || h.Get("Sunset") != sunset {
+			t.Errorf("%s expects deprecation %q %q but actual %q %q", path, deprecation, sunset, h.Get("Deprecation"), h.Get("Sunset"))
+		}
+	}
+	Expect("/v1/vehicleService/status/a1", `"v1 a1"`, "@1767225600", "Fri, 01 Jan 2027 00:00:00 GMT")
+	Expect("/v2/vehicleService/status/a1", `"v2 a1"`, "", "")
+
+	if versions := q.Versions(); len(versions) != 2 || versions[0] != "v1" || versions[1] != "v2" {
+		t.Errorf("expects versions v1 v2 but actual %v", versions)
+	}
+	if op := q.SwaggerSpec().Paths.Paths["/v1/vehicleService/status/{vin}"].Get; op == nil || !op.Deprecated {
+		t.Errorf("expects v1 documented as deprecated but actual %v", op)
+	}
+	doc := q.SwaggerSpecOf("v2")
+	if doc.BasePath != "/v2" || doc.Info.Version != "v2" || len(doc.Paths.Paths) != 1 {
+		t.Errorf("unexpected v2 document %s %v", doc.BasePath, doc.Paths.Paths)
+	}
+	if op := doc.Paths.Paths["/vehicleService/status/{vin}"].Get; op == nil || op.Deprecated {
+		t.Errorf("expects v2 documented but actual %v", op)
+	}
+}
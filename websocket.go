@@ -0,0 +1,343 @@
+package quark
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// WS_ leading method names are websocket apis, e.g. WS_Chat_roomId(roomId string, conn quark.Socket),
+// they are routed as GET and the connection is upgraded before the method is called, handshakes from origins
+// other than the host are rejected unless the CORS of the api allows them
+const websocketMethodPrefix = "WS"
+
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	opContinuation = 0
+
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+
+	websocketGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultMaxMessageSize   = 32 << 20
+	maxControlPayloadLength = 125
+)
+
+var (
+	socketType = reflect.TypeOf(Socket{})
+
+	ErrSocketClosed = errors.New("websocket is closed")
+)
+
+// CloseError is returned by reads after the peer sends a close frame
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed with %d %s", e.Code, e.Reason)
+}
+
+// Socket is injected as a method parameter of websocket apis, messages are encoded by the negotiated codec
+type Socket struct {
+	c *wsConn
+}
+
+// Read reads the next data message and unmarshals it into v
+func (s Socket) Read(v interface{}) error {
+	_, b, e := s.c.readMessage()
+	if e != nil {
+		return e
+	}
+	return s.c.codec.Unmarshal(b, v)
+}
+
+// Write marshals v and sends it as one message, text for textual media types and binary for others
+func (s Socket) Write(v interface{}) error {
+	b, e := s.c.codec.Marshal(v)
+	if e != nil {
+		return e
+	}
+	return s.c.writeFrame(s.c.dataType, b)
+}
+
+// ReadMessage reads the next data message, control frames are handled internally
+func (s Socket) ReadMessage() (messageType int, p []byte, e error) {
+	return s.c.readMessage()
+}
+
+func (s Socket) WriteMessage(messageType int, p []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage && messageType != PingMessage {
+		return fmt.Errorf("invalid websocket message type %d", messageType)
+	}
+	return s.c.writeFrame(messageType, p)
+}
+
+// Close sends a normal close frame and closes the connection
+func (s Socket) Close() error {
+	return s.c.close(CloseNormal, "")
+}
+
+// CloseWith sends a close frame with code and reason, then closes the connection
+func (s Socket) CloseWith(code int, reason string) error {
+	return s.c.close(code, reason)
+}
+
+type wsConn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	writeLock      sync.Mutex
+	closeOnce      sync.Once
+	closed         bool
+	codec          Codec
+	dataType       int
+	maxMessageSize int64
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sameOrigin tells whether the Origin header names host, browsers send it with every websocket handshake
+// and the connection isn't guarded by CORS otherwise
+func sameOrigin(origin, host string) bool {
+	u, e := url.Parse(origin)
+	return e == nil && strings.EqualFold(u.Host, host)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bindSocket performs the opening handshake and hijacks the connection, the socket must be closed when the method returns
+func (a *Api) bindSocket(c *Console) Socket {
+	r := c.r
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		c.Halt(http.StatusBadRequest, errors.New("websocket upgrade required"))
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.w.Header().Set("Sec-WebSocket-Version", "13")
+		c.Halt(http.StatusUpgradeRequired, errors.New("unsupported websocket version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, e := base64.StdEncoding.DecodeString(key); e != nil || len(decoded) != 16 {
+		c.Halt(http.StatusBadRequest, errors.New("invalid Sec-WebSocket-Key"))
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+		if cors := c.quark.cors(a); cors == nil || !cors.allowOrigin(origin) {
+			c.Halt(http.StatusForbidden, fmt.Errorf("websocket origin %s not allowed", origin))
+		}
+	}
+	conn, brw, e := c.w.Hijack()
+	if e != nil {
+		c.Halt(http.StatusInternalServerError, e)
+	}
+	c.w.status = http.StatusSwitchingProtocols
+	ws := &wsConn{
+		conn:           conn,
+		br:             brw.Reader,
+		codec:          c.codec,
+		dataType:       TextMessage,
+		maxMessageSize: a.maxBodySize(),
+	}
+	if ws.codec == nil {
+		ws.codec = jsonCodec{c.quark}
+	}
+	if mt := c.mediaType; mt != "" && !strings.Contains(mt, "json") && !strings.Contains(mt, "xml") && !strings.Contains(mt, "yaml") {
+		ws.dataType = BinaryMessage
+	}
+	if ws.maxMessageSize <= 0 {
+		ws.maxMessageSize = defaultMaxMessageSize
+	}
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, e := conn.Write([]byte(handshake)); e != nil {
+		conn.Close()
+		panic(e)
+	}
+	return Socket{ws}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode int, payload []byte, e error) {
+	var head [2]byte
+	if _, e = io.ReadFull(ws.br, head[:]); e != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		e = ws.fail(CloseProtocolError, "reserved bits are set")
+		return
+	}
+	opcode = int(head[0] & 0x0f)
+	if head[1]&0x80 == 0 {
+		e = ws.fail(CloseProtocolError, "client frames must be masked")
+		return
+	}
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, e = io.ReadFull(ws.br, ext[:]); e != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, e = io.ReadFull(ws.br, ext[:]); e != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= CloseMessage && (length > maxControlPayloadLength || !fin) {
+		e = ws.fail(CloseProtocolError, "invalid control frame")
+		return
+	}
+	if length < 0 || length > ws.maxMessageSize {
+		e = ws.fail(CloseMessageTooBig, "message too big")
+		return
+	}
+	var mask [4]byte
+	if _, e = io.ReadFull(ws.br, mask[:]); e != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, e = io.ReadFull(ws.br, payload); e != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// readMessage assembles fragmented data frames, answers pings and returns CloseError on close frames
+func (ws *wsConn) readMessage() (messageType int, message []byte, e error) {
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if e = ws.writeFrame(PongMessage, payload); e != nil {
+				return 0, nil, e
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			ce := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			ws.close(ce.Code, "")
+			return 0, nil, ce
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "new message inside a fragmented one")
+			}
+			messageType = opcode
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "continuation without a message")
+			}
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, "unknown opcode")
+		}
+		if int64(len(message)+len(payload)) > ws.maxMessageSize {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, ws.fail(CloseInvalidPayload, "invalid utf8 text")
+			}
+			return
+		}
+	}
+}
+
+func (ws *wsConn) writeFrame(opcode int, payload []byte) error {
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+	if ws.closed {
+		return ErrSocketClosed
+	}
+	return ws.writeFrameLocked(opcode, payload)
+}
+
+func (ws *wsConn) writeFrameLocked(opcode int, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, ext[:]...)
+	}
+	frame = append(frame, payload...)
+	_, e := ws.conn.Write(frame)
+	return e
+}
+
+func (ws *wsConn) fail(code int, reason string) error {
+	ws.close(code, reason)
+	return &CloseError{code, reason}
+}
+
+func (ws *wsConn) close(code int, reason string) (e error) {
+	ws.closeOnce.Do(func() {
+		ws.writeLock.Lock()
+		defer ws.writeLock.Unlock()
+		payload := make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if code == CloseNoStatus {
+			payload = nil
+		}
+		ws.writeFrameLocked(CloseMessage, payload)
+		ws.closed = true
+		e = ws.conn.Close()
+	})
+	return
+}
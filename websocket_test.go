@@ -0,0 +1,163 @@
+package quark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type websocketService struct {
+	Console
+}
+
+func (s websocketService) WS_Chat_roomId(roomId string, conn Socket) error {
+	for {
+		var g Greeting
+		if e := conn.Read(&g); e != nil {
+			var ce *CloseError
+			if errors.As(e, &ce) {
+				return nil
+			}
+			return e
+		}
+		g.Name = roomId + ":" + g.Name
+		if e := conn.Write(g); e != nil {
+			return e
+		}
+	}
+}
+
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebsocket(t *testing.T, url, origin string) *wsClient {
+	c, status := handshakeWebsocket(t, url, origin)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("expects 101 but actual %d", status)
+	}
+	return c
+}
+
+// handshakeWebsocket sends the opening handshake with Origin unless it's blank
+func handshakeWebsocket(t *testing.T, url, origin string) (*wsClient, int) {
+	conn, e := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET /websocketService/chat/lobby HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + key + "\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	req += "\r\n"
+	if _, e = conn.Write([]byte(req)); e != nil {
+		t.Fatal(e)
+	}
+	br := bufio.NewReader(conn)
+	rsp, e := http.ReadResponse(br, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if rsp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, rsp.StatusCode
+	}
+	if accept := rsp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept %s", accept)
+	}
+	return &wsClient{conn, br}, rsp.StatusCode
+}
+
+func (c *wsClient) write(opcode byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *wsClient) read() (opcode byte, payload []byte, e error) {
+	var head [2]byte
+	if _, e = io.ReadFull(c.br, head[:]); e != nil {
+		return
+	}
+	payload = make([]byte, head[1]&0x7f)
+	_, e = io.ReadFull(c.br, payload)
+	return head[0] & 0x0f, payload, e
+}
+
+func TestWebsocket(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(websocketService{})
+	if api := q.Services[0].Apis[0]; api.Method != http.MethodGet || api.Path != "/chat/{s}" {
+		t.Fatalf("unexpected websocket api %s %s", api.Method, api.Path)
+	}
+	server := httptest.NewServer(q)
+	defer server.Close()
+
+	rsp, e := http.Get(server.URL + "/websocketService/chat/lobby")
+	if e != nil {
+		t.Fatal(e)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET expects 400 but actual %d", rsp.StatusCode)
+	}
+
+	c := dialWebsocket(t, server.URL, "")
+	defer c.conn.Close()
+	c.write(TextMessage, []byte(`{"name":"a","times":1}`))
+	op, payload, e := c.read()
+	if e != nil || op != TextMessage {
+		t.Fatalf("expects a text message but actual %d %v", op, e)
+	}
+	var g Greeting
+	if e = json.Unmarshal(payload, &g); e != nil || g.Name != "lobby:a" || g.Times != 1 {
+		t.Errorf("unexpected echo %s", payload)
+	}
+
+	c.write(PingMessage, []byte("hi"))
+	if op, payload, _ = c.read(); op != PongMessage || string(payload) != "hi" {
+		t.Errorf("expects pong hi but actual %d %s", op, payload)
+	}
+
+	closing := make([]byte, 2)
+	binary.BigEndian.PutUint16(closing, CloseNormal)
+	c.write(CloseMessage, closing)
+	if op, payload, _ = c.read(); op != CloseMessage || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("expects close 1000 but actual %d %v", op, payload)
+	}
+	if _, _, e = c.read(); e != io.EOF {
+		t.Errorf("expects the connection closed but actual %v", e)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	q := NewQuark()
+	q.RegisterService(websocketService{})
+	server := httptest.NewServer(q)
+	defer server.Close()
+
+	dialWebsocket(t, server.URL, "http://x").conn.Close()
+	if _, status := handshakeWebsocket(t, server.URL, "https://evil.example.com"); status != http.StatusForbidden {
+		t.Errorf("cross origin expects 403 but actual %d", status)
+	}
+
+	q.WithCORS(CORS{AllowOrigins: []string{"https://*.example.com"}})
+	dialWebsocket(t, server.URL, "https://app.example.com").conn.Close()
+	if _, status := handshakeWebsocket(t, server.URL, "https://example.org"); status != http.StatusForbidden {
+		t.Errorf("origin out of CORS expects 403 but actual %d", status)
+	}
+}